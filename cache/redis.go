@@ -50,31 +50,27 @@ func InitRedis() {
 	fmt.Println("Connected to Redis successfully!")
 }
 
-// BlacklistToken adds a JWT token to the blacklist with 24-hour expiry
-func BlacklistToken(token string) error {
-	return RDB.Set(context.Background(), "blacklist:"+token, "1", 24*time.Hour).Err()
+// RevokeToken revokes an access token by its ID until the token would have expired anyway
+func RevokeToken(jti string, expiresAt time.Time) error {
+	return RevokeTokenIn(context.Background(), RDB, jti, expiresAt)
 }
 
-// IsTokenBlacklisted checks if a token is in the blacklist
-func IsTokenBlacklisted(token string) (bool, error) {
-	exists, err := RDB.Exists(context.Background(), "blacklist:"+token).Result()
-	return exists == 1, err
+// RevokeTokenIn revokes an access token using the given client or transaction
+func RevokeTokenIn(ctx context.Context, rdb redis.Cmdable, jti string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return rdb.Set(ctx, "revoked_jti:"+jti, "1", ttl).Err()
 }
 
-// RemoveExpiredTokens cleans up expired tokens (this runs periodically)
-func RemoveExpiredTokens() {
-	ctx := context.Background()
-
-	// Get all keys that start with "blacklist:"
-	iter := RDB.Scan(ctx, 0, "blacklist:*", 0).Iterator()
-	for iter.Next(ctx) {
-		key := iter.Val()
+// IsTokenRevoked checks if a token ID has been revoked
+func IsTokenRevoked(jti string) (bool, error) {
+	exists, err := RDB.Exists(context.Background(), "revoked_jti:"+jti).Result()
+	return exists == 1, err
+}
 
-		// Check if the key has expired
-		ttl, err := RDB.TTL(ctx, key).Result()
-		if err == nil && ttl <= 0 {
-			fmt.Println("Removing expired token")
-			RDB.Del(ctx, key)
-		}
-	}
+// DeviceTokenKey is the key holding the latest access token issued to a device
+func DeviceTokenKey(mobile, device string) string {
+	return fmt.Sprintf("device_token:%s:%s", mobile, device)
 }
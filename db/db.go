@@ -19,4 +19,6 @@ func InitDB() {
 		log.Fatalf("Failed to connect to DB: %v", err)
 	}
 	fmt.Println("Database connected successfully!")
+
+	Migrate()
 }
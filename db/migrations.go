@@ -0,0 +1,74 @@
+package db
+
+import (
+	"log"
+)
+
+// migrations bring the schema up to date. Each statement is idempotent so they can run on every start.
+var migrations = []string{
+	`CREATE TABLE IF NOT EXISTS users (
+		id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+		mobile TEXT NOT NULL UNIQUE
+	)`,
+	`CREATE TABLE IF NOT EXISTS user_devices (
+		mobile TEXT NOT NULL,
+		device_fingerprint TEXT NOT NULL
+	)`,
+
+	// Registration is only complete once the number is verified; existing users predate verification
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'active'`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS verified_at TIMESTAMPTZ`,
+	`CREATE INDEX IF NOT EXISTS users_pending_created_at_idx ON users (created_at) WHERE status = 'pending'`,
+
+	// Sessions are first-class records; Redis only caches them
+	`CREATE TABLE IF NOT EXISTS sessions (
+		id UUID PRIMARY KEY,
+		user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+		mobile TEXT NOT NULL,
+		device_id TEXT NOT NULL,
+		ip TEXT NOT NULL DEFAULT '',
+		user_agent TEXT NOT NULL DEFAULT '',
+		status TEXT NOT NULL DEFAULT 'active',
+		created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		last_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+		revoked_at TIMESTAMPTZ
+	)`,
+	`CREATE INDEX IF NOT EXISTS sessions_user_id_idx ON sessions (user_id, status)`,
+
+	// Sessions have an absolute lifetime; existing sessions get the default one
+	`ALTER TABLE sessions ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ NOT NULL DEFAULT NOW() + INTERVAL '720 hours'`,
+	`CREATE INDEX IF NOT EXISTS sessions_active_last_seen_at_idx ON sessions (last_seen_at) WHERE status = 'active'`,
+
+	// Devices are identified by an issued ID; existing devices keep their fingerprint as their ID
+	`ALTER TABLE user_devices ADD COLUMN IF NOT EXISTS device_id TEXT`,
+	`UPDATE user_devices SET device_id = device_fingerprint WHERE device_id IS NULL`,
+	`DELETE FROM user_devices a USING user_devices b WHERE a.ctid < b.ctid AND a.mobile = b.mobile AND a.device_id = b.device_id`,
+	`ALTER TABLE user_devices ALTER COLUMN device_id SET NOT NULL`,
+	`CREATE UNIQUE INDEX IF NOT EXISTS user_devices_mobile_device_id_idx ON user_devices (mobile, device_id)`,
+
+	// Device metadata shown to users
+	`ALTER TABLE user_devices ADD COLUMN IF NOT EXISTS name TEXT NOT NULL DEFAULT 'Unknown device'`,
+	`ALTER TABLE user_devices ADD COLUMN IF NOT EXISTS os TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE user_devices ADD COLUMN IF NOT EXISTS browser TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE user_devices ADD COLUMN IF NOT EXISTS app_version TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE user_devices ADD COLUMN IF NOT EXISTS first_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW()`,
+	`ALTER TABLE user_devices ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMPTZ NOT NULL DEFAULT NOW()`,
+	`ALTER TABLE user_devices ADD COLUMN IF NOT EXISTS last_ip TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE user_devices ADD COLUMN IF NOT EXISTS location TEXT NOT NULL DEFAULT ''`,
+
+	// Trusted devices log in without an OTP while their credential is current; admins can require OTPs per user
+	`ALTER TABLE user_devices ADD COLUMN IF NOT EXISTS trust_id TEXT`,
+	`ALTER TABLE user_devices ADD COLUMN IF NOT EXISTS trusted_until TIMESTAMPTZ`,
+	`ALTER TABLE user_devices ADD COLUMN IF NOT EXISTS trust_secret_hash TEXT`,
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS require_otp BOOLEAN NOT NULL DEFAULT FALSE`,
+}
+
+// Migrate applies the schema migrations
+func Migrate() {
+	for _, statement := range migrations {
+		if _, err := DB.Exec(statement); err != nil {
+			log.Fatalf("Failed to migrate database: %v", err)
+		}
+	}
+}
@@ -0,0 +1,19 @@
+package db
+
+import (
+	"fmt"
+	"time"
+)
+
+// RemoveExpiredRegistrations deletes pending users that never verified their number (this runs periodically)
+func RemoveExpiredRegistrations(maxAge time.Duration) {
+	result, err := DB.Exec("DELETE FROM users WHERE status = 'pending' AND created_at < $1", time.Now().Add(-maxAge))
+	if err != nil {
+		fmt.Println("Failed to remove expired registrations:", err)
+		return
+	}
+
+	if removed, _ := result.RowsAffected(); removed > 0 {
+		fmt.Printf("Removed %d expired registrations\n", removed)
+	}
+}
@@ -0,0 +1,370 @@
+package delivery
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"otp-auth-system/cache"
+	"otp-auth-system/otp"
+	"otp-auth-system/sms"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Delivery statuses reported to clients
+const (
+	StatusQueued    = "queued"
+	StatusSent      = "sent"
+	StatusFailed    = "failed"
+	StatusDelivered = "delivered"
+)
+
+// Delivery channels
+const (
+	ChannelSMS   = "sms"
+	ChannelVoice = "voice"
+)
+
+// Redis keys used by the queue
+const (
+	queueKey      = "otp_delivery:queue"
+	processingKey = "otp_delivery:processing" // Jobs taken by a worker and not yet finished
+	leaseKey      = "otp_delivery:leases"     // When each processing job was first seen
+	retryKey      = "otp_delivery:retry"
+	deadLetterKey = "otp_delivery:dead"
+	statusPrefix  = "otp_delivery:status:"
+)
+
+// leaseTimeout is how long a job may stay in processing before it is assumed lost with its
+// worker and queued again. It must exceed the send timeout.
+const leaseTimeout = 2 * time.Minute
+
+// statusTTL is how long a delivery status can be polled after its last update
+const statusTTL = 24 * time.Hour
+
+// ErrNotFound is returned when a delivery ID is unknown or its status has expired
+var ErrNotFound = errors.New("delivery not found")
+
+// Job is a single OTP send waiting in the queue
+type Job struct {
+	ID        string    `json:"id"`
+	Mobile    string    `json:"mobile"`
+	SealedOTP string    `json:"sealed_otp"`         // The OTP, encrypted with the queue key
+	LegacyOTP string    `json:"otp,omitempty"`      // Plaintext OTP of jobs queued before encryption
+	OTPKey    string    `json:"otp_key"`            // Redis key of the stored OTP, removed if delivery ultimately fails
+	OTPHash   string    `json:"otp_hash,omitempty"` // Hash of that OTP, so a newer one under the same key is kept
+	Channel   string    `json:"channel,omitempty"`  // ChannelSMS when empty
+	Attempt   int       `json:"attempt"`
+	CreatedAt time.Time `json:"created_at"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// Status is the delivery state returned to clients
+type Status struct {
+	ID        string    `json:"delivery_id"`
+	Status    string    `json:"status"`
+	Attempts  int       `json:"attempts"`
+	Provider  string    `json:"provider,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Retry policy; maxAttempts is read from DELIVERY_MAX_ATTEMPTS when the workers start
+var (
+	maxAttempts = 5
+	baseBackoff = 2 * time.Second
+	maxBackoff  = time.Minute
+)
+
+// promoteScript atomically moves jobs whose retry time has passed back onto the queue
+var promoteScript = redis.NewScript(`
+local jobs = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, 100)
+for _, job in ipairs(jobs) do
+	redis.call("ZREM", KEYS[1], job)
+	redis.call("LPUSH", KEYS[2], job)
+end
+return #jobs
+`)
+
+// recoverScript queues again any job that has been processing for longer than the lease timeout.
+// A job's lease starts when this script first sees it, so a job taken a moment ago is never recovered.
+var recoverScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local timeout = tonumber(ARGV[2])
+local recovered = 0
+for _, job in ipairs(redis.call("LRANGE", KEYS[1], 0, -1)) do
+	local since = redis.call("ZSCORE", KEYS[2], job)
+	if not since then
+		redis.call("ZADD", KEYS[2], now, job)
+	elseif now - tonumber(since) >= timeout then
+		redis.call("LREM", KEYS[1], 1, job)
+		redis.call("ZREM", KEYS[2], job)
+		redis.call("LPUSH", KEYS[3], job)
+		recovered = recovered + 1
+	end
+end
+redis.call("ZREMRANGEBYSCORE", KEYS[2], "-inf", now - 10 * timeout)
+return recovered
+`)
+
+// Enqueue queues an OTP for delivery over the channel and returns its delivery ID. otpKey and
+// otpHash identify the stored OTP, which is removed if delivery ultimately fails.
+func Enqueue(ctx context.Context, mobile string, code string, otpKey string, otpHash string, channel string) (string, error) {
+	job := Job{
+		ID:        uuid.NewString(),
+		Mobile:    mobile,
+		OTPKey:    otpKey,
+		OTPHash:   otpHash,
+		Channel:   channel,
+		CreatedAt: time.Now().UTC(),
+	}
+	sealed, err := seal(job.ID, code)
+	if err != nil {
+		return "", err
+	}
+	job.SealedOTP = sealed
+
+	payload, err := json.Marshal(job)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = cache.RDB.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, statusPrefix+job.ID, map[string]interface{}{
+			"status":     StatusQueued,
+			"attempts":   0,
+			"updated_at": job.CreatedAt.Unix(),
+		})
+		pipe.Expire(ctx, statusPrefix+job.ID, statusTTL)
+		pipe.LPush(ctx, queueKey, payload)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return job.ID, nil
+}
+
+// GetStatus returns the current status of a delivery
+func GetStatus(ctx context.Context, id string) (*Status, error) {
+	fields, err := cache.RDB.HGetAll(ctx, statusPrefix+id).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		return nil, ErrNotFound
+	}
+
+	attempts, _ := strconv.Atoi(fields["attempts"])
+	updatedAt, _ := strconv.ParseInt(fields["updated_at"], 10, 64)
+	return &Status{
+		ID:        id,
+		Status:    fields["status"],
+		Attempts:  attempts,
+		Provider:  fields["provider"],
+		UpdatedAt: time.Unix(updatedAt, 0).UTC(),
+	}, nil
+}
+
+// providers holds the provider for each delivery channel
+type providers struct {
+	sms   sms.Provider
+	voice sms.Provider // nil when voice delivery is not configured
+}
+
+// StartWorkers launches DELIVERY_WORKERS delivery workers and the retry scheduler.
+// voice may be nil when calls are not offered.
+func StartWorkers(ctx context.Context, provider sms.Provider, voice sms.Provider) {
+	workerCount := envInt("DELIVERY_WORKERS", 4)
+	maxAttempts = envInt("DELIVERY_MAX_ATTEMPTS", maxAttempts)
+
+	channels := &providers{sms: provider, voice: voice}
+	for i := 0; i < workerCount; i++ {
+		go work(ctx, channels)
+	}
+	go scheduleRetries(ctx)
+
+	fmt.Printf("Started %d OTP delivery workers\n", workerCount)
+}
+
+// work processes jobs from the queue until ctx is cancelled. Each job is moved to the processing
+// list while it is sent, so a job whose worker dies is recovered instead of lost.
+func work(ctx context.Context, channels *providers) {
+	for ctx.Err() == nil {
+		payload, err := cache.RDB.BLMove(ctx, queueKey, processingKey, "RIGHT", "LEFT", 5*time.Second).Result()
+		if err != nil {
+			if !errors.Is(err, redis.Nil) && ctx.Err() == nil {
+				log.Printf("Delivery queue read failed: %v", err)
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+
+		var job Job
+		if err := json.Unmarshal([]byte(payload), &job); err != nil {
+			log.Printf("Dropping malformed delivery job: %v", err)
+		} else {
+			process(ctx, channels, &job)
+		}
+		finish(ctx, payload)
+	}
+}
+
+// finish removes a job from the processing list once it was sent, rescheduled or dead-lettered
+func finish(ctx context.Context, payload string) {
+	_, err := cache.RDB.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LRem(ctx, processingKey, 1, payload)
+		pipe.ZRem(ctx, leaseKey, payload)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to finish delivery job: %v", err)
+	}
+}
+
+// process attempts a single send and schedules a retry or dead-letters the job on failure
+func process(ctx context.Context, channels *providers, job *Job) {
+	job.Attempt++
+
+	provider := channels.sms
+	if job.Channel == ChannelVoice {
+		provider = channels.voice
+	}
+	if provider == nil {
+		job.LastError = "no provider for channel " + job.Channel
+		deadLetter(ctx, job)
+		return
+	}
+
+	code := job.LegacyOTP
+	if job.SealedOTP != "" {
+		var err error
+		if code, err = open(job.ID, job.SealedOTP); err != nil {
+			job.LastError = "cannot decrypt OTP: " + err.Error()
+			deadLetter(ctx, job)
+			return
+		}
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	msg, err := provider.SendOTP(sendCtx, job.Mobile, code)
+	cancel()
+
+	if err == nil {
+		updateStatus(ctx, job.ID, map[string]interface{}{
+			"status":     StatusSent,
+			"attempts":   job.Attempt,
+			"provider":   msg.Provider,
+			"message_id": msg.ID,
+		})
+		// Remember the provider's reference so delivery reports can find this job
+		if msg.ID != "" {
+			cache.RDB.Set(ctx, messageKey(msg.Provider, msg.ID), job.ID, statusTTL)
+		}
+		return
+	}
+
+	job.LastError = err.Error()
+	log.Printf("OTP delivery %s attempt %d failed: %v", job.ID, job.Attempt, err)
+
+	if job.Attempt >= maxAttempts {
+		deadLetter(ctx, job)
+		return
+	}
+
+	payload, _ := json.Marshal(job)
+	retryAt := time.Now().Add(backoff(job.Attempt))
+	if err := cache.RDB.ZAdd(ctx, retryKey, redis.Z{Score: float64(retryAt.UnixMilli()), Member: payload}).Err(); err != nil {
+		log.Printf("Failed to schedule retry for delivery %s: %v", job.ID, err)
+		deadLetter(ctx, job)
+		return
+	}
+	updateStatus(ctx, job.ID, map[string]interface{}{"status": StatusQueued, "attempts": job.Attempt})
+}
+
+// deadLetter parks a job that exhausted its retries and revokes its OTP so no orphaned code stays
+// valid. An OTP issued after this job's is kept.
+func deadLetter(ctx context.Context, job *Job) {
+	// The OTP itself is never kept in the dead-letter list
+	job.SealedOTP = ""
+	job.LegacyOTP = ""
+	payload, _ := json.Marshal(job)
+
+	_, err := cache.RDB.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, deadLetterKey, payload)
+		pipe.LTrim(ctx, deadLetterKey, 0, 9999)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to dead-letter delivery %s: %v", job.ID, err)
+	}
+	if job.OTPKey != "" && job.OTPHash != "" {
+		if err := otp.Discard(ctx, job.OTPKey, job.OTPHash); err != nil {
+			log.Printf("Failed to revoke OTP of delivery %s: %v", job.ID, err)
+		}
+	}
+	updateStatus(ctx, job.ID, map[string]interface{}{"status": StatusFailed, "attempts": job.Attempt})
+}
+
+// scheduleRetries moves due retries, and jobs lost with their worker, back onto the queue once a second
+func scheduleRetries(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+			if err := promoteScript.Run(ctx, cache.RDB, []string{retryKey, queueKey}, now).Err(); err != nil {
+				log.Printf("Failed to promote delivery retries: %v", err)
+			}
+			timeout := strconv.FormatInt(leaseTimeout.Milliseconds(), 10)
+			recovered, err := recoverScript.Run(ctx, cache.RDB, []string{processingKey, leaseKey, queueKey}, now, timeout).Int()
+			if err != nil {
+				log.Printf("Failed to recover delivery jobs: %v", err)
+			} else if recovered > 0 {
+				log.Printf("Recovered %d delivery jobs from stopped workers", recovered)
+			}
+		}
+	}
+}
+
+// updateStatus records a status change for a delivery
+func updateStatus(ctx context.Context, id string, fields map[string]interface{}) {
+	fields["updated_at"] = time.Now().Unix()
+
+	_, err := cache.RDB.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, statusPrefix+id, fields)
+		pipe.Expire(ctx, statusPrefix+id, statusTTL)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to update delivery %s status: %v", id, err)
+	}
+}
+
+// backoff returns the delay before the next attempt, doubling with each failure
+func backoff(attempt int) time.Duration {
+	delay := baseBackoff << (attempt - 1)
+	if delay <= 0 || delay > maxBackoff {
+		return maxBackoff
+	}
+	return delay
+}
+
+// envInt reads a positive integer setting, falling back to def when unset or invalid
+func envInt(key string, def int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil && value > 0 {
+		return value
+	}
+	return def
+}
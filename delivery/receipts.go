@@ -0,0 +1,111 @@
+package delivery
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"otp-auth-system/cache"
+	"otp-auth-system/sms"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis keys used for delivery receipts and carrier metrics
+const (
+	messagePrefix        = "otp_delivery:message:"
+	carrierMetricsPrefix = "sms_metrics:carrier:"
+	carrierSetKey        = "sms_metrics:carriers"
+)
+
+// CarrierStats summarises delivery reports received for one carrier
+type CarrierStats struct {
+	Carrier      string  `json:"carrier"`
+	Delivered    int64   `json:"delivered"`
+	Failed       int64   `json:"failed"`
+	DeliveryRate float64 `json:"delivery_rate"`
+}
+
+// messageKey maps a provider's message reference back to our delivery ID
+func messageKey(provider, messageID string) string {
+	return messagePrefix + provider + ":" + messageID
+}
+
+// countReceiptScript counts a delivery report towards its carrier's metrics, once per known delivery
+var countReceiptScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 or redis.call("HSETNX", KEYS[1], "receipt_counted", "1") == 0 then
+	return 0
+end
+redis.call("SADD", KEYS[2], ARGV[1])
+redis.call("HINCRBY", KEYS[3], ARGV[2], 1)
+return 1
+`)
+
+// RecordReceipt applies a provider delivery report to the matching delivery and carrier metrics.
+// Only the first report for a message we sent is counted, so retried, replayed or forged webhooks
+// cannot skew the metrics. Reports for unknown messages are ignored.
+func RecordReceipt(ctx context.Context, provider string, receipt sms.Receipt) error {
+	id, err := cache.RDB.Get(ctx, messageKey(provider, receipt.MessageID)).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// Providers retry webhooks, so a repeated final status is ignored
+	current, err := cache.RDB.HGet(ctx, statusPrefix+id, "status").Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return err
+	}
+	if current == receipt.Status {
+		return nil
+	}
+
+	carrier := strings.ToLower(strings.TrimSpace(receipt.Carrier))
+	if carrier == "" {
+		carrier = "unknown"
+	}
+	keys := []string{statusPrefix + id, carrierSetKey, carrierMetricsPrefix + carrier}
+	if err := countReceiptScript.Run(ctx, cache.RDB, keys, carrier, receipt.Status).Err(); err != nil {
+		return err
+	}
+
+	fields := map[string]interface{}{"status": receipt.Status}
+	if receipt.Carrier != "" {
+		fields["carrier"] = receipt.Carrier
+	}
+	if receipt.ErrorCode != "" {
+		fields["error_code"] = receipt.ErrorCode
+	}
+	updateStatus(ctx, id, fields)
+	return nil
+}
+
+// CarrierMetrics returns delivery rates per carrier, ordered by carrier name
+func CarrierMetrics(ctx context.Context) ([]CarrierStats, error) {
+	carriers, err := cache.RDB.SMembers(ctx, carrierSetKey).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(carriers)
+
+	stats := make([]CarrierStats, 0, len(carriers))
+	for _, carrier := range carriers {
+		counts, err := cache.RDB.HGetAll(ctx, carrierMetricsPrefix+carrier).Result()
+		if err != nil {
+			return nil, err
+		}
+
+		delivered, _ := strconv.ParseInt(counts[sms.ReceiptDelivered], 10, 64)
+		failed, _ := strconv.ParseInt(counts[sms.ReceiptFailed], 10, 64)
+		entry := CarrierStats{Carrier: carrier, Delivered: delivered, Failed: failed}
+		if total := delivered + failed; total > 0 {
+			entry.DeliveryRate = float64(delivered) / float64(total)
+		}
+		stats = append(stats, entry)
+	}
+	return stats, nil
+}
@@ -0,0 +1,65 @@
+package delivery
+
+import (
+	"context"
+	"testing"
+
+	"otp-auth-system/cache"
+	"otp-auth-system/sms"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// carrierStats returns the metrics of one carrier, or zero values when it has none
+func carrierStats(t *testing.T, carrier string) CarrierStats {
+	t.Helper()
+	stats, err := CarrierMetrics(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range stats {
+		if entry.Carrier == carrier {
+			return entry
+		}
+	}
+	return CarrierStats{Carrier: carrier}
+}
+
+func TestRecordReceiptCountsOnlyTheFirstReceipt(t *testing.T) {
+	server := miniredis.RunT(t)
+	cache.RDB = redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { cache.RDB.Close() })
+	ctx := context.Background()
+
+	// A message we sent and are waiting to hear about
+	server.Set(messageKey("msg91", "message-1"), "delivery-1")
+	server.HSet(statusPrefix+"delivery-1", "status", StatusSent)
+
+	receipt := sms.Receipt{MessageID: "message-1", Status: sms.ReceiptDelivered, Carrier: "Jio"}
+	if err := RecordReceipt(ctx, "msg91", receipt); err != nil {
+		t.Fatal(err)
+	}
+	if stats := carrierStats(t, "jio"); stats.Delivered != 1 || stats.Failed != 0 {
+		t.Fatalf("after the first receipt: %+v, want 1 delivered", stats)
+	}
+
+	// Replays, with the same or a different status, and receipts for unknown messages change nothing
+	replays := []struct {
+		provider string
+		receipt  sms.Receipt
+	}{
+		{"msg91", receipt},
+		{"msg91", sms.Receipt{MessageID: "message-1", Status: sms.ReceiptFailed, Carrier: "Jio"}},
+		{"msg91", sms.Receipt{MessageID: "forged", Status: sms.ReceiptFailed, Carrier: "Jio"}},
+		{"twilio", receipt},
+	}
+	for _, replay := range replays {
+		if err := RecordReceipt(ctx, replay.provider, replay.receipt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := carrierStats(t, "jio"); stats.Delivered != 1 || stats.Failed != 0 {
+		t.Errorf("after replays: %+v, want 1 delivered and 0 failed", stats)
+	}
+}
@@ -0,0 +1,67 @@
+package delivery
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// aead encrypts OTPs while they wait in the queue, so read access to Redis does not reveal them
+var aead cipher.AEAD
+
+// Init derives the queue encryption key from DELIVERY_ENCRYPTION_KEY, falling back to OTP_HMAC_KEY
+// and then JWT_SECRET
+func Init() error {
+	secret := os.Getenv("DELIVERY_ENCRYPTION_KEY")
+	if secret == "" {
+		secret = os.Getenv("OTP_HMAC_KEY")
+	}
+	if secret == "" {
+		secret = os.Getenv("JWT_SECRET")
+	}
+	if secret == "" {
+		return fmt.Errorf("DELIVERY_ENCRYPTION_KEY is not set")
+	}
+
+	// Derive a separate key so the queue never shares key material with the OTP hashes
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("otp-delivery-queue"))
+	block, err := aes.NewCipher(mac.Sum(nil))
+	if err != nil {
+		return err
+	}
+	aead, err = cipher.NewGCM(block)
+	return err
+}
+
+// seal encrypts an OTP for a job, binding it to the job ID
+func seal(id, otp string) (string, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(otp), []byte(id))
+	return base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// open decrypts the OTP sealed for a job
+func open(id, sealed string) (string, error) {
+	data, err := base64.RawStdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+	if len(data) < aead.NonceSize() {
+		return "", errors.New("sealed OTP is too short")
+	}
+	otp, err := aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], []byte(id))
+	if err != nil {
+		return "", err
+	}
+	return string(otp), nil
+}
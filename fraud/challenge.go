@@ -0,0 +1,70 @@
+package fraud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// defaultChallengeVerifyURL is Cloudflare Turnstile's verification endpoint. reCAPTCHA and
+// hCaptcha accept the same form, so either can be used by setting FRAUD_CHALLENGE_VERIFY_URL.
+const defaultChallengeVerifyURL = "https://challenges.cloudflare.com/turnstile/v0/siteverify"
+
+var (
+	challengeSecret    string
+	challengeVerifyURL string
+	challengeClient    = &http.Client{Timeout: 5 * time.Second}
+)
+
+// initChallenge loads the challenge provider settings. Without FRAUD_CHALLENGE_SECRET no challenge
+// can be verified, so sends that need one are blocked.
+func initChallenge() {
+	challengeSecret = os.Getenv("FRAUD_CHALLENGE_SECRET")
+	challengeVerifyURL = os.Getenv("FRAUD_CHALLENGE_VERIFY_URL")
+	if challengeVerifyURL == "" {
+		challengeVerifyURL = defaultChallengeVerifyURL
+	}
+	if challengeSecret == "" {
+		log.Println("FRAUD_CHALLENGE_SECRET is not set, suspicious OTP sends cannot pass a challenge")
+	}
+}
+
+// verifyChallenge checks a challenge token with the provider
+func verifyChallenge(ctx context.Context, token, ip string) (bool, error) {
+	if token == "" || challengeSecret == "" {
+		return false, nil
+	}
+
+	form := url.Values{"secret": {challengeSecret}, "response": {token}}
+	if ip != "" {
+		form.Set("remoteip", ip)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, challengeVerifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := challengeClient.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("challenge verification returned %s", resp.Status)
+	}
+
+	var result struct {
+		Success bool `json:"success"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, err
+	}
+	return result.Success, nil
+}
@@ -0,0 +1,276 @@
+package fraud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"otp-auth-system/cache"
+	"otp-auth-system/sms"
+)
+
+// Actions taken on an OTP send
+const (
+	Allow     = "allow"
+	Challenge = "challenge" // Allowed only with a valid challenge token
+	Block     = "block"
+)
+
+// Signal weights added to a request's score
+const (
+	deniedWeight        = 100
+	notAllowedWeight    = 50
+	lowConversionWeight = 40
+	velocityWeight      = 40
+	ipNumbersWeight     = 40
+	ipConversionWeight  = 30
+)
+
+// decisionsKey holds the most recent decisions for review
+const decisionsKey = "fraud:decisions"
+
+// maxStoredDecisions caps the decision log in Redis
+const maxStoredDecisions = 10000
+
+// Request describes an OTP send to score
+type Request struct {
+	Mobile         string
+	IP             string
+	Channel        string
+	ChallengeToken string // Challenge (CAPTCHA) token from the client, if it has one
+}
+
+// Decision is the outcome of scoring an OTP send
+type Decision struct {
+	Action    string    `json:"action"`
+	Score     int       `json:"score"`
+	Reasons   []string  `json:"reasons"`
+	Prefix    string    `json:"prefix"`
+	Mobile    string    `json:"mobile"` // Masked
+	IP        string    `json:"ip"`
+	Channel   string    `json:"channel"`
+	DecidedAt time.Time `json:"decided_at"`
+}
+
+// Settings, read from the environment by Init
+var (
+	allowPrefixes  []string
+	denyPrefixes   []string
+	denyNetworks   []*net.IPNet
+	prefixLength   = 6
+	challengeScore = 40
+	blockScore     = 80
+)
+
+// Init loads the fraud settings:
+//   - FRAUD_ALLOW_PREFIXES and FRAUD_DENY_PREFIXES: comma-separated international prefixes such as "+91,+1"
+//   - FRAUD_DENY_IPS: comma-separated IP addresses or CIDR ranges
+//   - FRAUD_PREFIX_LENGTH: digits of the international number that form its prefix (default 6)
+//   - FRAUD_CHALLENGE_SCORE and FRAUD_BLOCK_SCORE: score thresholds (default 40 and 80)
+//   - FRAUD_CHALLENGE_SECRET and FRAUD_CHALLENGE_VERIFY_URL: challenge verification, see initChallenge
+func Init() error {
+	allowPrefixes = parsePrefixes(os.Getenv("FRAUD_ALLOW_PREFIXES"))
+	denyPrefixes = parsePrefixes(os.Getenv("FRAUD_DENY_PREFIXES"))
+
+	denyNetworks = nil
+	for _, entry := range strings.Split(os.Getenv("FRAUD_DENY_IPS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return fmt.Errorf("invalid FRAUD_DENY_IPS entry %q", entry)
+		}
+		denyNetworks = append(denyNetworks, network)
+	}
+
+	var err error
+	if prefixLength, err = envInt("FRAUD_PREFIX_LENGTH", prefixLength); err != nil {
+		return err
+	}
+	if challengeScore, err = envInt("FRAUD_CHALLENGE_SCORE", challengeScore); err != nil {
+		return err
+	}
+	if blockScore, err = envInt("FRAUD_BLOCK_SCORE", blockScore); err != nil {
+		return err
+	}
+
+	initChallenge()
+	return nil
+}
+
+// Evaluate scores an OTP send and decides whether to allow it, require a challenge or block it.
+// A send that needs a challenge is allowed when the request carries a valid challenge token.
+// Every decision is logged.
+func Evaluate(ctx context.Context, request Request) (*Decision, error) {
+	number := sms.InternationalNumber(request.Mobile)
+	decision := &Decision{
+		Reasons:   []string{},
+		Prefix:    prefix(number),
+		Mobile:    mask(number),
+		IP:        request.IP,
+		Channel:   request.Channel,
+		DecidedAt: time.Now().UTC(),
+	}
+	add := func(weight int, reason string) {
+		decision.Score += weight
+		decision.Reasons = append(decision.Reasons, reason)
+	}
+
+	// Destination lists
+	switch {
+	case matchesPrefix(number, denyPrefixes):
+		add(deniedWeight, "denied_prefix")
+	case len(allowPrefixes) > 0 && !matchesPrefix(number, allowPrefixes):
+		add(notAllowedWeight, "prefix_not_allowed")
+	}
+
+	// Source lists
+	if deniedIP(request.IP) {
+		add(deniedWeight, "denied_ip")
+	}
+
+	// Behaviour of the prefix and IP
+	signals, err := readSignals(ctx, decision.Prefix, request.IP)
+	if err != nil {
+		return nil, err
+	}
+	if signals.lowPrefixConversion() {
+		add(lowConversionWeight, "low_prefix_conversion")
+	}
+	if signals.velocitySpike() {
+		add(velocityWeight, "prefix_velocity_spike")
+	}
+	if signals.ipManyNumbers() {
+		add(ipNumbersWeight, "ip_many_numbers")
+	}
+	if signals.lowIPConversion() {
+		add(ipConversionWeight, "low_ip_conversion")
+	}
+
+	switch {
+	case decision.Score >= blockScore:
+		decision.Action = Block
+	case decision.Score >= challengeScore:
+		decision.Action = Challenge
+		if passed, err := verifyChallenge(ctx, request.ChallengeToken, request.IP); err != nil {
+			log.Printf("Fraud challenge verification failed: %v", err)
+		} else if passed {
+			decision.Action = Allow
+			decision.Reasons = append(decision.Reasons, "challenge_passed")
+		}
+	default:
+		decision.Action = Allow
+	}
+
+	record(ctx, decision)
+	return decision, nil
+}
+
+// RecentDecisions returns up to limit of the most recent decisions, newest first
+func RecentDecisions(ctx context.Context, limit int) ([]Decision, error) {
+	entries, err := cache.RDB.LRange(ctx, decisionsKey, 0, int64(limit)-1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	decisions := make([]Decision, 0, len(entries))
+	for _, entry := range entries {
+		var decision Decision
+		if json.Unmarshal([]byte(entry), &decision) == nil {
+			decisions = append(decisions, decision)
+		}
+	}
+	return decisions, nil
+}
+
+// record logs a decision and keeps it in Redis for review
+func record(ctx context.Context, decision *Decision) {
+	log.Printf("Fraud decision: action=%s score=%d reasons=%s prefix=%s mobile=%s ip=%s channel=%s",
+		decision.Action, decision.Score, strings.Join(decision.Reasons, ","), decision.Prefix, decision.Mobile, decision.IP, decision.Channel)
+
+	payload, err := json.Marshal(decision)
+	if err != nil {
+		return
+	}
+	cache.RDB.LPush(ctx, decisionsKey, payload)
+	cache.RDB.LTrim(ctx, decisionsKey, 0, maxStoredDecisions-1)
+}
+
+// parsePrefixes splits a comma-separated list of international prefixes into digits
+func parsePrefixes(value string) []string {
+	var prefixes []string
+	for _, entry := range strings.Split(value, ",") {
+		if digits := strings.TrimPrefix(strings.TrimSpace(entry), "+"); digits != "" {
+			prefixes = append(prefixes, strings.ReplaceAll(digits, " ", ""))
+		}
+	}
+	return prefixes
+}
+
+// matchesPrefix reports whether an international number starts with any of the prefixes
+func matchesPrefix(number string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(number, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// deniedIP reports whether an IP address falls in a denied range
+func deniedIP(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range denyNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// prefix returns the leading digits of an international number that identify its range
+func prefix(number string) string {
+	if len(number) <= prefixLength {
+		return number
+	}
+	return number[:prefixLength]
+}
+
+// mask hides the middle of a number in logs
+func mask(number string) string {
+	if len(number) <= prefixLength+2 {
+		return number
+	}
+	return number[:prefixLength] + strings.Repeat("*", len(number)-prefixLength-2) + number[len(number)-2:]
+}
+
+// envInt reads a non-negative integer setting
+func envInt(key string, def int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return def, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		return 0, fmt.Errorf("invalid %s %q", key, value)
+	}
+	return parsed, nil
+}
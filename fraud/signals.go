@@ -0,0 +1,187 @@
+package fraud
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"otp-auth-system/cache"
+	"otp-auth-system/sms"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Conversion: the share of sent OTPs that are verified, per prefix and per IP
+const (
+	conversionTTL        = 48 * time.Hour
+	minConversionSample  = 20  // Sends needed before conversion is judged
+	lowPrefixConversion  = 0.2 // Genuine users verify most codes; pumped numbers never do
+	lowIPConversion      = 0.2
+	minIPConversionCount = 10
+)
+
+// Velocity: sends per prefix in fixed buckets, compared with the recent baseline
+const (
+	velocityBucket      = 5 * time.Minute
+	velocityBaseline    = 12 // Buckets averaged for the baseline (one hour)
+	minVelocitySpike    = 20 // Sends in the current bucket before a spike counts
+	velocitySpikeFactor = 5
+)
+
+// IP reputation: distinct numbers an IP sends codes to in a day
+const (
+	ipNumbersTTL  = 24 * time.Hour
+	maxIPNumbers  = 10
+	ipActivityTTL = 24 * time.Hour
+)
+
+// Fields of the conversion hashes
+const (
+	conversionSent = "sent"
+	conversionDone = "verified"
+)
+
+// signals are the counters read for a send
+type signals struct {
+	prefixSent     int64
+	prefixVerified int64
+	current        int64
+	previous       []int64
+	ipNumbers      int64
+	ipSent         int64
+	ipVerified     int64
+}
+
+func (s signals) lowPrefixConversion() bool {
+	return s.prefixSent >= minConversionSample && float64(s.prefixVerified)/float64(s.prefixSent) < lowPrefixConversion
+}
+
+func (s signals) velocitySpike() bool {
+	if s.current < minVelocitySpike {
+		return false
+	}
+	var total int64
+	for _, count := range s.previous {
+		total += count
+	}
+	average := float64(total) / float64(len(s.previous))
+	return float64(s.current) > velocitySpikeFactor*average
+}
+
+func (s signals) ipManyNumbers() bool {
+	return s.ipNumbers > maxIPNumbers
+}
+
+func (s signals) lowIPConversion() bool {
+	return s.ipSent >= minIPConversionCount && float64(s.ipVerified)/float64(s.ipSent) < lowIPConversion
+}
+
+// readSignals loads the prefix and IP counters in one round trip
+func readSignals(ctx context.Context, prefix, ip string) (signals, error) {
+	now := time.Now()
+	bucket := now.Unix() / int64(velocityBucket.Seconds())
+
+	pipe := cache.RDB.Pipeline()
+	prefixConversion := pipe.HMGet(ctx, conversionKey(prefix, now), conversionSent, conversionDone)
+	velocity := make([]*redis.StringCmd, velocityBaseline+1)
+	for i := range velocity {
+		velocity[i] = pipe.Get(ctx, velocityKey(prefix, bucket-int64(i)))
+	}
+	var ipNumbers *redis.IntCmd
+	var ipConversion *redis.SliceCmd
+	if ip != "" {
+		ipNumbers = pipe.PFCount(ctx, ipNumbersKey(ip))
+		ipConversion = pipe.HMGet(ctx, ipKey(ip), conversionSent, conversionDone)
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return signals{}, err
+	}
+
+	var s signals
+	s.prefixSent, s.prefixVerified = counts(prefixConversion.Val())
+	s.current, _ = velocity[0].Int64()
+	for _, cmd := range velocity[1:] {
+		count, _ := cmd.Int64()
+		s.previous = append(s.previous, count)
+	}
+	if ip != "" {
+		s.ipNumbers = ipNumbers.Val()
+		s.ipSent, s.ipVerified = counts(ipConversion.Val())
+	}
+	return s, nil
+}
+
+// RecordSent counts an OTP sent to a mobile number from an IP address
+func RecordSent(ctx context.Context, mobile, ip string) error {
+	now := time.Now()
+	p := prefix(sms.InternationalNumber(mobile))
+	bucket := now.Unix() / int64(velocityBucket.Seconds())
+
+	_, err := cache.RDB.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		key := conversionKey(p, now)
+		pipe.HIncrBy(ctx, key, conversionSent, 1)
+		pipe.Expire(ctx, key, conversionTTL)
+
+		key = velocityKey(p, bucket)
+		pipe.Incr(ctx, key)
+		pipe.Expire(ctx, key, velocityBucket*(velocityBaseline+2))
+
+		if ip != "" {
+			pipe.PFAdd(ctx, ipNumbersKey(ip), mobile)
+			pipe.Expire(ctx, ipNumbersKey(ip), ipNumbersTTL)
+			pipe.HIncrBy(ctx, ipKey(ip), conversionSent, 1)
+			pipe.Expire(ctx, ipKey(ip), ipActivityTTL)
+		}
+		return nil
+	})
+	return err
+}
+
+// RecordVerified counts an OTP verified for a mobile number from an IP address
+func RecordVerified(ctx context.Context, mobile, ip string) error {
+	p := prefix(sms.InternationalNumber(mobile))
+
+	_, err := cache.RDB.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		key := conversionKey(p, time.Now())
+		pipe.HIncrBy(ctx, key, conversionDone, 1)
+		pipe.Expire(ctx, key, conversionTTL)
+
+		if ip != "" {
+			pipe.HIncrBy(ctx, ipKey(ip), conversionDone, 1)
+			pipe.Expire(ctx, ipKey(ip), ipActivityTTL)
+		}
+		return nil
+	})
+	return err
+}
+
+// counts parses the sent and verified fields of a conversion hash
+func counts(values []interface{}) (sent, verified int64) {
+	if len(values) != 2 {
+		return 0, 0
+	}
+	if value, ok := values[0].(string); ok {
+		sent, _ = strconv.ParseInt(value, 10, 64)
+	}
+	if value, ok := values[1].(string); ok {
+		verified, _ = strconv.ParseInt(value, 10, 64)
+	}
+	return sent, verified
+}
+
+func conversionKey(prefix string, day time.Time) string {
+	return "fraud:conversion:" + prefix + ":" + day.UTC().Format("2006-01-02")
+}
+
+func velocityKey(prefix string, bucket int64) string {
+	return "fraud:velocity:" + prefix + ":" + strconv.FormatInt(bucket, 10)
+}
+
+func ipNumbersKey(ip string) string {
+	return "fraud:ip_numbers:" + ip
+}
+
+func ipKey(ip string) string {
+	return "fraud:ip:" + ip
+}
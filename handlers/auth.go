@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"otp-auth-system/db"
+	"otp-auth-system/delivery"
+	"otp-auth-system/models"
+	"otp-auth-system/otp"
+	"otp-auth-system/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AuthStartRequest defines the request body for starting passwordless authentication
+type AuthStartRequest struct {
+	Mobile string `json:"mobile"`
+}
+
+// AuthCompleteRequest defines the request body for completing passwordless authentication
+type AuthCompleteRequest struct {
+	ChallengeID string `json:"challenge_id"`
+	OTP         string `json:"otp"`
+}
+
+// StartAuth starts passwordless authentication for any mobile number
+// @Summary Start authentication
+// @Description Sends an OTP to the mobile number and returns an opaque challenge ID. Unknown numbers get a pending account, so the response never reveals whether a number is registered.
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param request body handlers.AuthStartRequest true "User's mobile number"
+// @Success 200 {object} map[string]string
+// @Failure 400 {object} map[string]string
+// @Failure 429 {object} map[string]interface{}
+// @Failure 500 {object} map[string]string
+// @Router /auth/start [post]
+func StartAuth(c *gin.Context) {
+	var request struct {
+		Mobile string `json:"mobile"`
+	}
+
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	if !validMobile(c, request.Mobile) {
+		return
+	}
+
+	if rateLimited(c, ratelimit.Login, request.Mobile) {
+		return
+	}
+
+	// Create a pending user for unknown numbers; a returning pending user restarts the pending period
+	// so the expired-registration sweep cannot remove it while the challenge is open. Active users are
+	// left untouched.
+	_, err := db.DB.Exec(`INSERT INTO users (mobile, status) VALUES ($1, $2)
+		ON CONFLICT (mobile) DO UPDATE SET created_at = NOW() WHERE users.status = $2`, request.Mobile, models.UserStatusPending)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start authentication"})
+		return
+	}
+
+	challengeID, err := otp.NewChallenge(c.Request.Context(), request.Mobile)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start authentication"})
+		return
+	}
+
+	deliveryID, _, ok := sendOTP(c, request.Mobile, otp.Scope{Purpose: otp.PurposeAuth, Context: challengeID}, delivery.ChannelSMS)
+	if !ok {
+		otp.DeleteChallenge(context.Background(), challengeID)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "OTP sent via SMS", "challenge_id": challengeID, "delivery_id": deliveryID})
+}
+
+// CompleteAuth completes passwordless authentication with the challenge ID and OTP
+// @Summary Complete authentication
+// @Description Verifies the OTP sent for a challenge, activates the account if it is new and returns a JWT
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param request body handlers.AuthCompleteRequest true "Challenge ID and OTP"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]interface{}
+// @Failure 429 {object} map[string]interface{}
+// @Failure 500 {object} map[string]string
+// @Router /auth/complete [post]
+func CompleteAuth(c *gin.Context) {
+	var request struct {
+		ChallengeID string `json:"challenge_id"`
+		OTP         string `json:"otp"`
+	}
+
+	if err := c.BindJSON(&request); err != nil || request.ChallengeID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	mobile, err := otp.ChallengeMobile(c.Request.Context(), request.ChallengeID)
+	if err != nil {
+		respondOTPError(c, err)
+		return
+	}
+
+	if rateLimited(c, ratelimit.Verify, mobile) {
+		return
+	}
+
+	// The OTP must have been issued for this challenge
+	err = otp.Verify(c.Request.Context(), mobile, request.OTP, otp.Scope{Purpose: otp.PurposeAuth, Context: request.ChallengeID})
+	if err != nil {
+		respondOTPError(c, err)
+		return
+	}
+	otp.DeleteChallenge(context.Background(), request.ChallengeID)
+
+	recordVerified(c, mobile)
+
+	// Verifying the number activates a pending account
+	result, err := db.DB.Exec("UPDATE users SET status = $1, verified_at = NOW() WHERE mobile = $2 AND status = $3",
+		models.UserStatusActive, mobile, models.UserStatusPending)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to activate user"})
+		return
+	}
+	newUser, _ := result.RowsAffected()
+
+	response := issueLoginToken(c, mobile)
+	if response == nil {
+		return
+	}
+
+	response["message"] = "Authentication successful"
+	response["new_user"] = newUser > 0
+	c.JSON(http.StatusOK, response)
+}
@@ -1,26 +1,41 @@
 package handlers
 
 import (
+	"database/sql"
+	"errors"
+	"fmt"
 	"net/http"
 	"otp-auth-system/db"
-	"otp-auth-system/utils"
+	"otp-auth-system/models"
+	"otp-auth-system/sessions"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/gin-gonic/gin"
 )
 
 // DeviceRequest DeleteDeviceRequest defines the request body for removing a device
 type DeviceRequest struct {
-	DeviceFingerprint string `json:"device_fingerprint"`
+	DeviceID          string `json:"device_id"`
+	DeviceFingerprint string `json:"device_fingerprint,omitempty"` // Deprecated: use device_id
 }
 
+// RenameDeviceRequest defines the request body for renaming a device
+type RenameDeviceRequest struct {
+	Name string `json:"name"`
+}
+
+// maxDeviceNameLength limits user-chosen device names
+const maxDeviceNameLength = 64
+
 // GetRegisteredDevices retrieves all registered devices for a user
 // @Summary Get registered devices
-// @Description Returns a list of devices where the user has logged in
+// @Description Returns the devices where the user has logged in, most recently seen first. The device making the request is marked as current.
 // @Tags Devices
 // @Security BearerToken
 // @Accept json
 // @Produce json
-// @Success 200 {array} string
+// @Success 200 {object} map[string]interface{}
 // @Failure 401 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /user/devices [get]
@@ -31,30 +46,86 @@ func GetRegisteredDevices(c *gin.Context) {
 		return
 	}
 
-	var devices []string
-	err := db.DB.Select(&devices, "SELECT device_fingerprint FROM user_devices WHERE mobile = $1", mobile)
+	devices := []models.Device{}
+	err := db.DB.Select(&devices, "SELECT * FROM user_devices WHERE mobile = $1 ORDER BY last_seen_at DESC", mobile)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch registered devices"})
 		return
 	}
 
+	currentDevice := c.GetString("device_id")
+	for i := range devices {
+		devices[i].Current = devices[i].ID == currentDevice
+	}
+
 	c.JSON(http.StatusOK, gin.H{"devices": devices})
 }
 
+// RenameDevice sets the name of a registered device
+// @Summary Rename a device
+// @Description Sets a friendly name for one of the user's devices
+// @Tags Devices
+// @Security BearerToken
+// @Accept json
+// @Produce json
+// @Param id path string true "Device ID"
+// @Param request body handlers.RenameDeviceRequest true "New device name"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /user/devices/{id} [patch]
+func RenameDevice(c *gin.Context) {
+	mobile, exists := c.Get("mobile")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var request struct {
+		Name string `json:"name"`
+	}
+
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+	name := strings.TrimSpace(request.Name)
+	if name == "" || utf8.RuneCountInString(name) > maxDeviceNameLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Name must be 1 to %d characters", maxDeviceNameLength)})
+		return
+	}
+
+	var device models.Device
+	err := db.DB.Get(&device, "UPDATE user_devices SET name = $1 WHERE mobile = $2 AND device_id = $3 RETURNING *", name, mobile, c.Param("id"))
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rename device"})
+		return
+	}
+	device.Current = device.ID == c.GetString("device_id")
+
+	c.JSON(http.StatusOK, gin.H{"message": "Device renamed successfully", "device": device})
+}
+
 // RemoveRegisteredDevice deletes a specific registered device
 // @Summary Remove a specific device
-// @Description Deletes a registered device from the user's account
+// @Description Deletes a registered device from the user's account and terminates its sessions. Every access and refresh token issued to the device stops working. device_fingerprint is still accepted in place of device_id but is deprecated.
 // @Tags Devices
 // @Security BearerToken
 // @Accept json
 // @Produce json
-// @Param request body handlers.DeviceRequest true "Device Fingerprint "
-// @Success 200 {object} map[string]string
+// @Param request body handlers.DeviceRequest true "Device ID"
+// @Success 200 {object} map[string]interface{}
 // @Failure 400 {object} map[string]string
 // @Failure 401 {object} map[string]string
 // @Failure 404 {object} map[string]string
 // @Failure 500 {object} map[string]string
-// @Router /delete [delete]
+// @Router /device [delete]
 func RemoveRegisteredDevice(c *gin.Context) {
 	mobile, exists := c.Get("mobile")
 	if !exists {
@@ -63,38 +134,54 @@ func RemoveRegisteredDevice(c *gin.Context) {
 	}
 
 	var request struct {
+		DeviceID          string `json:"device_id"`
 		DeviceFingerprint string `json:"device_fingerprint"`
 	}
 
-	if err := c.BindJSON(&request); err != nil {
+	if err := c.BindJSON(&request); err != nil || (request.DeviceID == "" && request.DeviceFingerprint == "") {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
 		return
 	}
 
-	// Delete the specific device from user_devices table
-	result, err := db.DB.Exec("DELETE FROM user_devices WHERE mobile = $1 AND device_fingerprint = $2", mobile, request.DeviceFingerprint)
+	// Clients written before device IDs name the device by its fingerprint; still accepted for now
+	deviceID := request.DeviceID
+	if deviceID == "" {
+		c.Header("Deprecation", "true")
+		err := db.DB.Get(&deviceID, `SELECT device_id FROM user_devices WHERE mobile = $1 AND device_fingerprint = $2
+			ORDER BY last_seen_at DESC LIMIT 1`, mobile, request.DeviceFingerprint)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove device"})
+			return
+		}
+	}
+
+	// Delete the device and revoke everything issued to it together
+	removed, terminated, err := sessions.RemoveDevice(c.Request.Context(), mobile.(string), deviceID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove device"})
 		return
 	}
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
+	if removed == 0 {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Device not found"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Device removed successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Device removed successfully", "sessions_terminated": terminated})
 }
 
 // RemoveAllOtherDevices removes all devices except the current one
 // @Summary Remove all devices except current
-// @Description Logs out all devices except the currently active one
+// @Description Logs out all devices except the currently active one, revoking their access and refresh tokens
 // @Tags Devices
 // @Security BearerToken
 // @Accept json
 // @Produce json
-// @Success 200 {object} map[string]string
+// @Success 200 {object} map[string]interface{}
 // @Failure 401 {object} map[string]string
 // @Failure 500 {object} map[string]string
 // @Router /devices/all [delete]
@@ -105,21 +192,18 @@ func RemoveAllOtherDevices(c *gin.Context) {
 		return
 	}
 
-	// Generate the fingerprint for the current device
-	currentFingerprint := utils.GenerateFingerprint(c.Request)
-
-	// Ensure the current device is NOT removed
-	result, err := db.DB.Exec("DELETE FROM user_devices WHERE mobile = $1 AND device_fingerprint != $2", mobile, currentFingerprint)
+	// The current device is the one the access token was issued to; ensure it is NOT removed
+	currentDevice := c.GetString("device_id")
+	removed, terminated, err := sessions.RemoveOtherDevices(c.Request.Context(), mobile.(string), currentDevice)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove devices"})
 		return
 	}
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		c.JSON(http.StatusOK, gin.H{"message": "No other devices found"})
+	if removed == 0 {
+		c.JSON(http.StatusOK, gin.H{"message": "No other devices found", "sessions_terminated": 0})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "All other devices removed successfully, current device remains"})
+	c.JSON(http.StatusOK, gin.H{"message": "All other devices removed successfully, current device remains", "sessions_terminated": terminated})
 }
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"otp-auth-system/fraud"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fraudBlocked scores an OTP send for SMS pumping. It writes the 403 response and returns true
+// when the send is blocked or needs a challenge the request did not pass.
+func fraudBlocked(c *gin.Context, mobile, channel string) bool {
+	decision, err := fraud.Evaluate(c.Request.Context(), fraud.Request{
+		Mobile:         mobile,
+		IP:             c.ClientIP(),
+		Channel:        channel,
+		ChallengeToken: c.GetHeader("X-Challenge-Token"),
+	})
+	if err != nil {
+		log.Printf("Failed to score OTP send: %v", err)
+		return false // Redis trouble should not stop every send
+	}
+
+	switch decision.Action {
+	case fraud.Block:
+		c.JSON(http.StatusForbidden, gin.H{"error": "OTP cannot be sent to this number"})
+		return true
+	case fraud.Challenge:
+		c.JSON(http.StatusForbidden, gin.H{
+			"error":              "Verification required before sending an OTP",
+			"challenge_required": true,
+		})
+		return true
+	}
+	return false
+}
+
+// recordSent counts an OTP send towards the prefix and IP conversion rates
+func recordSent(c *gin.Context, mobile string) {
+	if err := fraud.RecordSent(context.Background(), mobile, c.ClientIP()); err != nil {
+		log.Printf("Failed to record OTP send: %v", err)
+	}
+}
+
+// recordVerified counts an OTP verification towards the prefix and IP conversion rates
+func recordVerified(c *gin.Context, mobile string) {
+	if err := fraud.RecordVerified(context.Background(), mobile, c.ClientIP()); err != nil {
+		log.Printf("Failed to record OTP verification: %v", err)
+	}
+}
+
+// GetFraudDecisions lists recent fraud decisions on OTP sends
+// @Summary Recent fraud decisions
+// @Description Returns the most recent OTP send decisions with their score and reasons, newest first
+// @Tags Internal
+// @Param X-Internal-Token header string true "Internal API token"
+// @Param limit query int false "Number of decisions (default 100, max 1000)"
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /internal/fraud/decisions [get]
+func GetFraudDecisions(c *gin.Context) {
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	decisions, err := fraud.RecentDecisions(c.Request.Context(), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch fraud decisions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"decisions": decisions})
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+	"otp-auth-system/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetJWKS publishes the public keys used to verify access tokens
+// @Summary JSON Web Key Set
+// @Description Returns the public keys other services use to verify access tokens locally
+// @Tags Authentication
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Router /.well-known/jwks.json [get]
+func GetJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": utils.JWKS()})
+}
+
+// GetSigningKeys lists the signing keys in the key ring
+// @Summary List signing keys
+// @Description Returns every signing key with its status (pending, active or retired)
+// @Tags Internal
+// @Param X-Internal-Token header string true "Internal API token"
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 403 {object} map[string]string
+// @Router /internal/keys [get]
+func GetSigningKeys(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"keys": utils.SigningKeys()})
+}
+
+// RotateSigningKeys creates a new signing key
+// @Summary Rotate signing key
+// @Description Creates a new signing key in JWT_KEYS_DIR. It starts signing after JWT_KEY_ACTIVATION_DELAY; older keys keep verifying until their tokens expire.
+// @Tags Internal
+// @Param X-Internal-Token header string true "Internal API token"
+// @Produce json
+// @Success 200 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /internal/keys/rotate [post]
+func RotateSigningKeys(c *gin.Context) {
+	kid, err := utils.RotateKeys()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate signing key"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Signing key created", "kid": kid})
+}
@@ -2,54 +2,63 @@ package handlers
 
 import (
 	"context"
-	"fmt"
 	"net/http"
 	"otp-auth-system/cache"
-	"otp-auth-system/db"
+	"otp-auth-system/sessions"
+	"otp-auth-system/tokens"
+	"otp-auth-system/utils"
 
 	"github.com/gin-gonic/gin"
 )
 
 // Logout logs out the user from the current device
 // @Summary Logout from current device
-// @Description Invalidates JWT token from Redis for the current device
+// @Description Revokes the access token used for the request and ends its session
 // @Tags Authentication
 // @Security BearerToken
 // @Accept json
 // @Produce json
 // @Success 200 {object} map[string]string
 // @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
 // @Router /logout [post]
 func Logout(c *gin.Context) {
-	// Get token from Authorization header
-	tokenString := c.GetHeader("Authorization")
-	if tokenString == "" {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "No token provided"})
+	claims, exists := c.Get("claims")
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
+	tokenClaims := claims.(*utils.Claims)
 
-	// Blacklist the token
-	err := cache.BlacklistToken(tokenString)
+	// Revoke the token by its ID for the rest of its lifetime
+	err := cache.RevokeToken(tokenClaims.ID, tokenClaims.ExpiresAt.Time)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to logout"})
 		return
 	}
 
 	// Remove token from Redis session
-	cache.RDB.Del(context.Background(), "device_token:"+tokenString)
+	cache.RDB.Del(context.Background(), cache.DeviceTokenKey(tokenClaims.Mobile, tokenClaims.DeviceID))
+
+	// End the session, which also revokes its refresh tokens
+	if err := sessions.Revoke(c.Request.Context(), tokenClaims.SessionID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to logout"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
 }
 
 // LogoutAll logs out the user from all devices
 // @Summary Logout from all devices
-// @Description Invalidates JWT tokens for all devices of the user
+// @Description Invalidates JWT and refresh tokens for all devices of the user
 // @Tags Authentication
 // @Security BearerToken
 // @Accept json
 // @Produce json
-// @Success 200 {object} map[string]string
+// @Success 200 {object} map[string]interface{}
 // @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
 // @Router /logout/all [post]
 func LogoutAll(c *gin.Context) {
 	mobile, exists := c.Get("mobile")
@@ -58,30 +67,34 @@ func LogoutAll(c *gin.Context) {
 		return
 	}
 
-	// Retrieve user's device fingerprints from the database
-	var deviceFingerprints []string
-	err := db.DB.Select(&deviceFingerprints, "SELECT device_fingerprint FROM user_devices WHERE mobile = $1", mobile)
+	// Remove every device, revoking the sessions and tokens bound to them
+	_, terminated, err := sessions.RemoveAllDevices(c.Request.Context(), mobile.(string))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user devices"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove devices"})
 		return
 	}
 
-	// Blacklist only the JWTs associated with these devices
-	for _, device := range deviceFingerprints {
-		tokenKey := fmt.Sprintf("device_token:%s:%s", mobile, device)
-		token, err := cache.RDB.Get(context.Background(), tokenKey).Result()
-		if err == nil && token != "" {
-			cache.BlacklistToken(token)
-			cache.RDB.Del(context.Background(), tokenKey) // Remove device-token mapping
+	// Revoke the token used for this request
+	if tokenClaims, ok := c.MustGet("claims").(*utils.Claims); ok {
+		if err := cache.RevokeToken(tokenClaims.ID, tokenClaims.ExpiresAt.Time); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke tokens"})
+			return
 		}
 	}
 
-	// Remove all device records for the user
-	_, err = db.DB.Exec("DELETE FROM user_devices WHERE mobile = $1", mobile)
+	// End any session not bound to a registered device
+	unbound, err := sessions.RevokeAll(c.Request.Context(), c.GetString("user_id"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove devices"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+	terminated += unbound
+
+	// Revoke every refresh token so no device can obtain new access tokens
+	if err := tokens.RevokeAll(c.Request.Context(), mobile.(string)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke refresh tokens"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Logged out from all devices successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out from all devices successfully", "sessions_terminated": terminated})
 }
@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"otp-auth-system/cache"
+	"otp-auth-system/db"
+	"otp-auth-system/fraud"
+	"otp-auth-system/middleware"
+	"otp-auth-system/otp"
+	"otp-auth-system/ratelimit"
+	"otp-auth-system/sessions"
+	"otp-auth-system/tokens"
+	"otp-auth-system/utils"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	testMobile = "9876543210"
+	testUserID = "6f1c3a52-8d1e-4c4b-9b1e-2f7c6a0d9e11"
+	testOTP    = "123456"
+)
+
+// testServer runs the auth routes against miniredis and a mocked Postgres
+type testServer struct {
+	router *gin.Engine
+	mock   sqlmock.Sqlmock
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	redisServer := miniredis.RunT(t)
+	cache.RDB = redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+	t.Cleanup(func() { cache.RDB.Close() })
+
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.DB = sqlx.NewDb(conn, "postgres")
+	t.Cleanup(func() { conn.Close() })
+
+	t.Setenv("JWT_SECRET", "test-jwt-secret")
+	t.Setenv("OTP_HMAC_KEY", "test-otp-key")
+	if err := utils.InitJWT(); err != nil {
+		t.Fatal(err)
+	}
+	if err := otp.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ratelimit.Init(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fraud.Init(); err != nil {
+		t.Fatal(err)
+	}
+	tokens.Init()
+	sessions.Init()
+
+	router := gin.New()
+	router.POST("/verify", VerifyOTP)
+	protected := router.Group("/").Use(middleware.AuthMiddleware())
+	protected.GET("/user", GetCurrentUser)
+	protected.DELETE("/device", RemoveRegisteredDevice)
+	protected.POST("/logout", Logout)
+	protected.POST("/logout/all", LogoutAll)
+
+	return &testServer{router: router, mock: mock}
+}
+
+// do sends a request with an optional bearer token and JSON body
+func (s *testServer) do(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	var payload bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&payload).Encode(body)
+	}
+	req := httptest.NewRequest(method, path, &payload)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	s.router.ServeHTTP(rec, req)
+	return rec
+}
+
+// login is a completed /verify: the session and device it created, and the access token issued
+type login struct {
+	token     string
+	sessionID []byte
+	deviceID  []byte
+}
+
+// login verifies a login OTP for testMobile from a new device
+func (s *testServer) login(t *testing.T) *login {
+	t.Helper()
+	if _, _, err := otp.Issue(context.Background(), testMobile, testOTP, otp.Scope{Purpose: otp.PurposeLogin}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The session and device IDs are generated by the handler, so they are captured from the inserts
+	l := &login{sessionID: make([]byte, 36), deviceID: make([]byte, 36)}
+	s.mock.ExpectQuery(`SELECT id FROM users WHERE mobile`).WithArgs(testMobile).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(testUserID))
+	s.mock.ExpectExec(`INSERT INTO user_devices`).
+		WithArgs(testMobile, capture(l.deviceID), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectQuery(`INSERT INTO sessions`).
+		WithArgs(capture(l.sessionID), testUserID, testMobile, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), "active", sqlmock.AnyArg()).
+		WillReturnRows(sessionRows("active", l))
+
+	rec := s.do(http.MethodPost, "/verify", "", gin.H{"mobile": testMobile, "otp": testOTP})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("login: got %d %s", rec.Code, rec.Body)
+	}
+	var response struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || response.Token == "" {
+		t.Fatalf("login: no token in %s", rec.Body)
+	}
+	l.token = response.Token
+	return l
+}
+
+// expectAuthorized asserts that the token is still accepted
+func (s *testServer) expectAuthorized(t *testing.T, token string) {
+	t.Helper()
+	if rec := s.do(http.MethodGet, "/user", token, nil); rec.Code != http.StatusOK {
+		t.Fatalf("token rejected: got %d %s", rec.Code, rec.Body)
+	}
+}
+
+// expectRejected asserts that the token no longer works
+func (s *testServer) expectRejected(t *testing.T, token string) {
+	t.Helper()
+	if rec := s.do(http.MethodGet, "/user", token, nil); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("token accepted after revocation: got %d %s", rec.Code, rec.Body)
+	}
+}
+
+// capture matches any string argument of the buffer's length and copies it into the buffer
+type capture []byte
+
+func (c capture) Match(v driver.Value) bool {
+	value, ok := v.(string)
+	if !ok || len(value) != len(c) {
+		return false
+	}
+	copy(c, value)
+	return true
+}
+
+// sessionRows returns sessions rows for logins. The captured ID buffers are read when the rows
+// are scanned, after the handler has filled them in.
+func sessionRows(status string, logins ...*login) *sqlmock.Rows {
+	now := time.Now()
+	rows := sqlmock.NewRows([]string{"id", "user_id", "mobile", "device_id", "ip", "user_agent", "status",
+		"created_at", "last_seen_at", "expires_at", "revoked_at"})
+	for _, l := range logins {
+		var revokedAt interface{}
+		if status != "active" {
+			revokedAt = now
+		}
+		rows.AddRow(l.sessionID, testUserID, testMobile, l.deviceID, "192.0.2.1", "", status,
+			now, now, now.Add(time.Hour), revokedAt)
+	}
+	return rows
+}
+
+func TestLogoutRejectsToken(t *testing.T) {
+	s := newTestServer(t)
+	l := s.login(t)
+	s.expectAuthorized(t, l.token)
+
+	s.mock.ExpectQuery(`UPDATE sessions SET status`).WillReturnRows(sessionRows("revoked", l))
+	if rec := s.do(http.MethodPost, "/logout", l.token, nil); rec.Code != http.StatusOK {
+		t.Fatalf("logout: got %d %s", rec.Code, rec.Body)
+	}
+
+	s.expectRejected(t, l.token)
+	if err := s.mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLogoutRejectsTokenWithoutSession(t *testing.T) {
+	s := newTestServer(t)
+	l := s.login(t)
+
+	// Even if the session were still active, the token's ID is revoked
+	s.mock.ExpectQuery(`UPDATE sessions SET status`).WillReturnRows(sessionRows("revoked"))
+	if rec := s.do(http.MethodPost, "/logout", l.token, nil); rec.Code != http.StatusOK {
+		t.Fatalf("logout: got %d %s", rec.Code, rec.Body)
+	}
+
+	s.expectRejected(t, l.token)
+}
+
+func TestLogoutAllRejectsEveryToken(t *testing.T) {
+	s := newTestServer(t)
+	first := s.login(t)
+	second := s.login(t)
+	unbound := s.login(t) // Its device row is already gone, so only the session sweep ends it
+
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery(`DELETE FROM user_devices`).
+		WillReturnRows(sqlmock.NewRows([]string{"device_id"}).AddRow(first.deviceID).AddRow(second.deviceID))
+	s.mock.ExpectQuery(`UPDATE sessions SET status`).WillReturnRows(sessionRows("revoked", first, second))
+	s.mock.ExpectCommit()
+	s.mock.ExpectQuery(`UPDATE sessions SET status`).WillReturnRows(sessionRows("revoked", unbound))
+
+	rec := s.do(http.MethodPost, "/logout/all", first.token, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("logout all: got %d %s", rec.Code, rec.Body)
+	}
+	var response struct {
+		SessionsTerminated int `json:"sessions_terminated"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || response.SessionsTerminated != 3 {
+		t.Errorf("sessions_terminated = %d (%v), want 3", response.SessionsTerminated, err)
+	}
+
+	s.expectRejected(t, first.token)
+	s.expectRejected(t, second.token)
+	s.expectRejected(t, unbound.token)
+	if err := s.mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRemoveDeviceRejectsItsToken(t *testing.T) {
+	s := newTestServer(t)
+	current := s.login(t)
+	removed := s.login(t)
+
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery(`DELETE FROM user_devices`).
+		WillReturnRows(sqlmock.NewRows([]string{"device_id"}).AddRow(removed.deviceID))
+	s.mock.ExpectQuery(`UPDATE sessions SET status`).WillReturnRows(sessionRows("revoked", removed))
+	s.mock.ExpectCommit()
+
+	rec := s.do(http.MethodDelete, "/device", current.token, gin.H{"device_id": string(removed.deviceID)})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("remove device: got %d %s", rec.Code, rec.Body)
+	}
+
+	s.expectRejected(t, removed.token)
+	s.expectAuthorized(t, current.token)
+	if err := s.mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
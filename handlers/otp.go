@@ -2,32 +2,96 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"net/http"
-	"otp-auth-system/cache"
 	"otp-auth-system/db"
+	"otp-auth-system/delivery"
+	"otp-auth-system/models"
+	"otp-auth-system/otp"
+	"otp-auth-system/ratelimit"
 	"otp-auth-system/utils"
-	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-// OTP request limit settings
-const otpRequestLimit = 6          // Maximum OTP requests per hour (including login, register, resend)
-const otpBlockDuration = time.Hour // Duration before reset
+// isLockedOut rejects OTP requests for a number locked out after repeated failed verifications
+func isLockedOut(c *gin.Context, mobile string) bool {
+	lockedFor, err := otp.LockedFor(c.Request.Context(), mobile)
+	if err != nil || lockedFor <= 0 {
+		return false
+	}
 
-// Function to check OTP rate limit in Redis
-func isRateLimited(mobile string) bool {
-	requestCountKey := "otp_requests:" + mobile
-	requests, _ := cache.RDB.Get(context.Background(), requestCountKey).Int()
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       "Too many failed attempts. Try again later.",
+		"retry_after": setRetryAfter(c, lockedFor),
+	})
+	return true
+}
 
-	return requests >= otpRequestLimit
+// validMobile rejects malformed mobile numbers with 400. It returns false if the response was written.
+func validMobile(c *gin.Context, mobile string) bool {
+	if !utils.ValidMobile(mobile) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mobile number"})
+		return false
+	}
+	return true
 }
 
-// Function to increase OTP request count in Redis
-func incrementOTPRequestCount(mobile string) {
-	requestCountKey := "otp_requests:" + mobile
-	cache.RDB.Incr(context.Background(), requestCountKey)
-	cache.RDB.Expire(context.Background(), requestCountKey, otpBlockDuration)
+// sendOTP checks the number's lockout and the wait since its last OTP for the purpose, then issues
+// an OTP for the scope and queues it for delivery over the channel. Callers apply their endpoint's
+// rate limit first; the number's send limit shared by every endpoint is applied here. It writes the
+// error response and returns false if the OTP was not sent.
+func sendOTP(c *gin.Context, mobile string, scope otp.Scope, channel string) (string, *otp.Resend, bool) {
+	// Cap the OTPs sent to the number, whichever endpoint asks
+	if rateLimited(c, ratelimit.Send, mobile) {
+		return "", nil, false
+	}
+
+	// Check lockout
+	if isLockedOut(c, mobile) {
+		return "", nil, false
+	}
+
+	// Refuse sends that look like SMS pumping
+	if fraudBlocked(c, mobile, channel) {
+		return "", nil, false
+	}
+
+	// Enforce the growing wait between sends; the previous OTP stays valid until it is replaced
+	resend, err := otp.ReserveSend(c.Request.Context(), mobile, scope.Purpose, channel == delivery.ChannelVoice)
+	if err != nil {
+		respondResendError(c, err)
+		return "", nil, false
+	}
+
+	// Generate OTP
+	code, err := utils.GenerateOTP()
+	if err != nil {
+		resend.Release(context.Background())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate OTP"})
+		return "", nil, false
+	}
+
+	// Store hashed OTP in Redis with a 5-minute expiration
+	otpKey, otpHash, err := otp.Issue(c.Request.Context(), mobile, code, scope)
+	if err != nil {
+		resend.Release(context.Background())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store OTP"})
+		return "", nil, false
+	}
+
+	// Queue OTP for delivery via SMS or a call
+	deliveryID, err := delivery.Enqueue(c.Request.Context(), mobile, code, otpKey, otpHash, channel)
+	if err != nil {
+		otp.Discard(context.Background(), otpKey, otpHash) // Don't leave an undeliverable OTP behind
+		resend.Release(context.Background())
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send OTP via SMS"})
+		return "", nil, false
+	}
+
+	recordSent(c, mobile)
+
+	return deliveryID, resend, true
 }
 
 // RegisterRequest defines the request body for user registration
@@ -42,12 +106,14 @@ type LoginRequest struct {
 
 // ResendOTPRequest defines the request body for resending OTP
 type ResendOTPRequest struct {
-	Mobile string `json:"mobile"`
+	Mobile  string `json:"mobile"`
+	Purpose string `json:"purpose" example:"login"` // "login" (default) or "register"
+	Channel string `json:"channel" example:"sms"`   // "sms" (default) or "voice", once SMS resends are used up
 }
 
-// RegisterUser registers a new user
+// RegisterUser starts registration of a new user
 // @Summary Register a new user
-// @Description Registers a new user and sends OTP via SMS
+// @Description Creates a pending registration and sends a registration OTP via SMS. The account becomes active once the OTP is verified at /register/verify.
 // @Tags Authentication
 // @Accept json
 // @Produce json
@@ -55,6 +121,7 @@ type ResendOTPRequest struct {
 // @Success 200 {object} map[string]string
 // @Failure 400 {object} map[string]string
 // @Failure 409 {object} map[string]string
+// @Failure 429 {object} map[string]interface{}
 // @Failure 500 {object} map[string]string
 // @Router /register [post]
 func RegisterUser(c *gin.Context) {
@@ -67,9 +134,17 @@ func RegisterUser(c *gin.Context) {
 		return
 	}
 
-	// Check if user already exists
+	if !validMobile(c, request.Mobile) {
+		return
+	}
+
+	if rateLimited(c, ratelimit.Register, request.Mobile) {
+		return
+	}
+
+	// Check if an active user already exists
 	var exists bool
-	err := db.DB.Get(&exists, "SELECT EXISTS(SELECT 1 FROM users WHERE mobile = $1)", request.Mobile)
+	err := db.DB.Get(&exists, "SELECT EXISTS(SELECT 1 FROM users WHERE mobile = $1 AND status = $2)", request.Mobile, models.UserStatusActive)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
@@ -80,14 +155,20 @@ func RegisterUser(c *gin.Context) {
 		return
 	}
 
-	// Store user in DB (if new)
-	_, err = db.DB.Exec("INSERT INTO users (mobile) VALUES ($1) ON CONFLICT (mobile) DO NOTHING", request.Mobile)
+	// Store pending user in DB; registering again restarts the pending period
+	_, err = db.DB.Exec(`INSERT INTO users (mobile, status) VALUES ($1, $2)
+		ON CONFLICT (mobile) DO UPDATE SET created_at = NOW() WHERE users.status = $2`, request.Mobile, models.UserStatusPending)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register user"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "User registered successfully"})
+	deliveryID, _, ok := sendOTP(c, request.Mobile, otp.Scope{Purpose: otp.PurposeRegister}, delivery.ChannelSMS)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "OTP sent via SMS. Verify it to complete registration.", "delivery_id": deliveryID})
 }
 
 // LoginUser logs in a user via OTP
@@ -100,6 +181,7 @@ func RegisterUser(c *gin.Context) {
 // @Success 200 {object} map[string]string
 // @Failure 400 {object} map[string]string
 // @Failure 404 {object} map[string]string
+// @Failure 429 {object} map[string]interface{}
 // @Failure 500 {object} map[string]string
 // @Router /login [post]
 func LoginUser(c *gin.Context) {
@@ -112,58 +194,48 @@ func LoginUser(c *gin.Context) {
 		return
 	}
 
-	// Check if user exists
-	var exists bool
-	err := db.DB.Get(&exists, "SELECT EXISTS(SELECT 1 FROM users WHERE mobile = $1)", request.Mobile)
-	if err != nil || !exists {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	if !validMobile(c, request.Mobile) {
 		return
 	}
 
-	// Check rate limit
-	if isRateLimited(request.Mobile) {
-		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many OTP requests. Try again later."})
+	if rateLimited(c, ratelimit.Login, request.Mobile) {
 		return
 	}
 
-	// Generate OTP
-	otp := utils.GenerateOTP()
-
-	// Store OTP in Redis with a 5-minute expiration
-	err = cache.RDB.Set(context.Background(), request.Mobile, otp, 5*time.Minute).Err()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store OTP"})
+	// Check if an active user exists
+	var exists bool
+	err := db.DB.Get(&exists, "SELECT EXISTS(SELECT 1 FROM users WHERE mobile = $1 AND status = $2)", request.Mobile, models.UserStatusActive)
+	if err != nil || !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
 
-	// Increment OTP request count
-	incrementOTPRequestCount(request.Mobile)
-
-	// Send OTP via SMS
-	if err := utils.SendOTPViaSMS(request.Mobile, otp); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send OTP via SMS"})
+	deliveryID, _, ok := sendOTP(c, request.Mobile, otp.Scope{Purpose: otp.PurposeLogin}, delivery.ChannelSMS)
+	if !ok {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "OTP sent via SMS"})
+	c.JSON(http.StatusOK, gin.H{"message": "OTP sent via SMS", "delivery_id": deliveryID})
 }
 
 // ResendOTP sends a new OTP if the previous one expired
 // @Summary Resend OTP
-// @Description Requests a new OTP if the previous one expired
+// @Description Requests a new login OTP, or a new registration OTP for a pending registration. Each SMS resend must wait longer than the last (30s, 60s, 120s by default), counting OTPs sent by /login, /register and /auth/start; a rejected resend leaves the previous OTP valid. Once SMS resends are used up, the OTP can be requested as a voice call.
 // @Tags Authentication
 // @Accept json
 // @Produce json
-// @Param request body handlers.ResendOTPRequest true "User's mobile number"
-// @Success 200 {object} map[string]string
+// @Param request body handlers.ResendOTPRequest true "User's mobile number, OTP purpose and channel"
+// @Success 200 {object} map[string]interface{}
 // @Failure 400 {object} map[string]string
 // @Failure 404 {object} map[string]string
-// @Failure 429 {object} map[string]string
+// @Failure 429 {object} map[string]interface{}
 // @Failure 500 {object} map[string]string
 // @Router /resend-otp [post]
 func ResendOTP(c *gin.Context) {
 	var request struct {
-		Mobile string `json:"mobile"`
+		Mobile  string `json:"mobile"`
+		Purpose string `json:"purpose"`
+		Channel string `json:"channel"`
 	}
 
 	if err := c.BindJSON(&request); err != nil {
@@ -171,32 +243,112 @@ func ResendOTP(c *gin.Context) {
 		return
 	}
 
+	if !validMobile(c, request.Mobile) {
+		return
+	}
+
+	if rateLimited(c, ratelimit.Resend, request.Mobile) {
+		return
+	}
+
+	// Login OTPs go to active users, registration OTPs to pending ones
+	var status string
+	switch request.Purpose {
+	case "", otp.PurposeLogin:
+		request.Purpose = otp.PurposeLogin
+		status = models.UserStatusActive
+	case otp.PurposeRegister:
+		status = models.UserStatusPending
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid purpose"})
+		return
+	}
+
+	switch request.Channel {
+	case "", delivery.ChannelSMS:
+		request.Channel = delivery.ChannelSMS
+	case delivery.ChannelVoice:
+		if VoiceProvider == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Voice calls are not available"})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid channel"})
+		return
+	}
+
 	// Check if the user exists
 	var exists bool
-	err := db.DB.Get(&exists, "SELECT EXISTS(SELECT 1 FROM users WHERE mobile = $1)", request.Mobile)
+	err := db.DB.Get(&exists, "SELECT EXISTS(SELECT 1 FROM users WHERE mobile = $1 AND status = $2)", request.Mobile, status)
 	if err != nil || !exists {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
 
-	// Check rate limit
-	if isRateLimited(request.Mobile) {
-		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many OTP requests. Try again later."})
+	deliveryID, resend, ok := sendOTP(c, request.Mobile, otp.Scope{Purpose: request.Purpose}, request.Channel)
+	if !ok {
 		return
 	}
 
-	// Generate a new OTP
-	newOTP := utils.GenerateOTP()
-	cache.RDB.Set(context.Background(), request.Mobile, newOTP, 5*time.Minute)
+	message := "New OTP sent via SMS"
+	if request.Channel == delivery.ChannelVoice {
+		message = "New OTP will be read out in a phone call"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":           message,
+		"delivery_id":       deliveryID,
+		"resends_remaining": resend.Remaining,
+		"next_resend_after": seconds(resend.NextAfter),
+	})
+}
 
-	// Increment OTP request count
-	incrementOTPRequestCount(request.Mobile)
+// respondResendError writes the response for a resend that is not allowed yet, offering a call
+// once SMS resends are used up
+func respondResendError(c *gin.Context, err error) {
+	var resendErr *otp.ResendError
+	errors.As(err, &resendErr)
+
+	switch {
+	case errors.Is(err, otp.ErrResendCooldown):
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error":       "Please wait before requesting another OTP. Your previous OTP is still valid.",
+			"retry_after": setRetryAfter(c, resendErr.RetryAfter),
+		})
+	case errors.Is(err, otp.ErrResendsExhausted):
+		response := gin.H{"error": "No SMS resends left. Your previous OTP is still valid."}
+		if VoiceProvider != nil {
+			response["fallback_channels"] = []string{delivery.ChannelVoice}
+		} else {
+			response["retry_after"] = setRetryAfter(c, resendErr.RetryAfter)
+		}
+		c.JSON(http.StatusTooManyRequests, response)
+	case errors.Is(err, otp.ErrVoiceNotYet):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Voice calls are offered once SMS resends are used up"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send OTP"})
+	}
+}
 
-	// Send OTP via SMS
-	if err := utils.SendOTPViaSMS(request.Mobile, newOTP); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to send OTP via SMS"})
+// GetOTPDeliveryStatus reports the delivery status of an OTP send
+// @Summary Get OTP delivery status
+// @Description Returns whether an OTP is queued, sent, failed or delivered
+// @Tags Authentication
+// @Produce json
+// @Param id path string true "Delivery ID returned by /register, /login or /resend-otp"
+// @Success 200 {object} delivery.Status
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /otp/delivery/{id} [get]
+func GetOTPDeliveryStatus(c *gin.Context) {
+	status, err := delivery.GetStatus(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, delivery.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Delivery not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch delivery status"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "New OTP sent via SMS"})
+	c.JSON(http.StatusOK, status)
 }
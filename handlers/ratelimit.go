@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"otp-auth-system/ratelimit"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimited counts the request against the policy by mobile number, IP, device, number prefix
+// and globally. It writes the 429 response and returns true when any limit is exceeded. A request
+// checked against several policies reports the most restrictive result of all of them.
+func rateLimited(c *gin.Context, policy *ratelimit.Policy, mobile string) bool {
+	result, err := policy.Allow(c.Request.Context(), ratelimit.Subject{
+		Mobile: mobile,
+		IP:     c.ClientIP(),
+		Device: c.GetHeader("X-Device-ID"),
+	})
+	if err != nil {
+		log.Printf("Failed to check rate limit: %v", err)
+		return false // Redis trouble should not lock everyone out
+	}
+	result = mergeRateLimit(c, result)
+	setRateLimitHeaders(c, result)
+	if result.Allowed {
+		return false
+	}
+
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":       "Too many requests. Try again later.",
+		"retry_after": setRetryAfter(c, result.RetryAfter),
+	})
+	return true
+}
+
+// mergeRateLimit combines a policy's result with those of policies already checked for the request:
+// the fewest requests remaining and the longest reset win
+func mergeRateLimit(c *gin.Context, result *ratelimit.Result) *ratelimit.Result {
+	if value, ok := c.Get("rate_limit"); ok {
+		previous := value.(*ratelimit.Result)
+		merged := *result
+		if result.Limit == 0 || (previous.Limit != 0 && previous.Remaining < result.Remaining) {
+			merged.Dimension, merged.Limit, merged.Remaining = previous.Dimension, previous.Limit, previous.Remaining
+		}
+		merged.Reset = max(previous.Reset, result.Reset)
+		result = &merged
+	}
+	c.Set("rate_limit", result)
+	return result
+}
+
+// setRateLimitHeaders describes the most restrictive limit in RateLimit-Limit, RateLimit-Remaining
+// and RateLimit-Reset (seconds until the limit is fully restored)
+func setRateLimitHeaders(c *gin.Context, result *ratelimit.Result) {
+	if result.Limit == 0 {
+		return // No limits applied
+	}
+	c.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(seconds(result.Reset)))
+}
+
+// setRetryAfter sets the Retry-After header and returns the same number of seconds for the response body
+func setRetryAfter(c *gin.Context, wait time.Duration) int {
+	retryAfter := seconds(wait)
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	return retryAfter
+}
+
+// seconds rounds a duration up to whole seconds
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
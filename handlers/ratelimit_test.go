@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"otp-auth-system/cache"
+	"otp-auth-system/ratelimit"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRateLimitHeadersReportTheMostRestrictivePolicy(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	redisServer := miniredis.RunT(t)
+	cache.RDB = redis.NewClient(&redis.Options{Addr: redisServer.Addr()})
+	t.Cleanup(func() { cache.RDB.Close() })
+
+	endpoint := &ratelimit.Policy{Name: "endpoint", Rules: map[ratelimit.Dimension]ratelimit.Rule{
+		ratelimit.IP: {Algorithm: ratelimit.SlidingWindow, Limit: 2, Window: time.Minute},
+	}}
+	shared := &ratelimit.Policy{Name: "shared", Rules: map[ratelimit.Dimension]ratelimit.Rule{
+		ratelimit.Mobile: {Algorithm: ratelimit.SlidingWindow, Limit: 6, Window: time.Hour},
+	}}
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/login", nil)
+	if rateLimited(c, endpoint, testMobile) || rateLimited(c, shared, testMobile) {
+		t.Fatal("first request limited")
+	}
+
+	// The endpoint's IP limit has one request left, however many the shared limit has
+	if limit, remaining := recorder.Header().Get("RateLimit-Limit"), recorder.Header().Get("RateLimit-Remaining"); limit != "2" || remaining != "1" {
+		t.Errorf("RateLimit-Limit = %s, RateLimit-Remaining = %s, want 2 and 1", limit, remaining)
+	}
+	if reset := recorder.Header().Get("RateLimit-Reset"); reset != "3600" {
+		t.Errorf("RateLimit-Reset = %s, want 3600", reset)
+	}
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"otp-auth-system/sessions"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetSessions lists the active sessions of the user
+// @Summary List sessions
+// @Description Returns the user's active sessions with their device, IP, user agent and activity timestamps
+// @Tags Sessions
+// @Security BearerToken
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /user/sessions [get]
+func GetSessions(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	list, err := sessions.List(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"sessions":        list,
+		"current_session": c.GetString("session_id"),
+	})
+}
+
+// RevokeSession revokes one of the user's sessions
+// @Summary Revoke a session
+// @Description Ends a session; its access and refresh tokens stop working immediately
+// @Tags Sessions
+// @Security BearerToken
+// @Produce json
+// @Param id path string true "Session ID"
+// @Success 200 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /user/sessions/{id} [delete]
+func RevokeSession(c *gin.Context) {
+	userID := c.GetString("user_id")
+	if userID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// Users can only revoke their own sessions
+	session, err := sessions.Get(c.Request.Context(), c.Param("id"))
+	if errors.Is(err, sessions.ErrNotFound) || (err == nil && session.UserID.String() != userID) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+
+	if err := sessions.Revoke(c.Request.Context(), session.ID.String()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked successfully"})
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"net/http"
+	"otp-auth-system/delivery"
+	"otp-auth-system/sms"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SMSProvider is the provider used by the delivery workers; it is configured at startup
+var SMSProvider sms.Provider
+
+// VoiceProvider delivers OTPs in phone calls; it is nil when VOICE_PROVIDER is not set
+var VoiceProvider sms.Provider
+
+// GetSMSHealth reports the health of each configured SMS provider
+// @Summary SMS provider health
+// @Description Returns delivery counts, latency and circuit breaker state for each SMS provider
+// @Tags Internal
+// @Param X-Internal-Token header string true "Internal API token"
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 403 {object} map[string]string
+// @Router /internal/sms/health [get]
+func GetSMSHealth(c *gin.Context) {
+	reporter, ok := SMSProvider.(sms.HealthReporter)
+	if !ok {
+		c.JSON(http.StatusOK, gin.H{"providers": []sms.ProviderHealth{}})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"providers": reporter.Health()})
+}
+
+// GetCarrierMetrics reports delivery rates per mobile carrier from provider delivery reports
+// @Summary SMS delivery rates per carrier
+// @Description Returns delivered and failed counts and the delivery rate for each carrier
+// @Tags Internal
+// @Param X-Internal-Token header string true "Internal API token"
+// @Produce json
+// @Success 200 {object} map[string]interface{}
+// @Failure 403 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /internal/sms/carriers [get]
+func GetCarrierMetrics(c *gin.Context) {
+	metrics, err := delivery.CarrierMetrics(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch carrier metrics"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"carriers": metrics})
+}
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"otp-auth-system/cache"
+	"otp-auth-system/db"
+	"otp-auth-system/models"
+	"otp-auth-system/sessions"
+	"otp-auth-system/tokens"
+	"otp-auth-system/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RefreshTokenRequest defines the request body for refreshing an access token
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshToken exchanges a refresh token for a new access token and refresh token
+// @Summary Refresh access token
+// @Description Rotates the refresh token and issues a new access token. Each refresh token can be used once; reusing one revokes every token issued from the same login.
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param request body handlers.RefreshTokenRequest true "Refresh token"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /token/refresh [post]
+func RefreshToken(c *gin.Context) {
+	var request struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	if err := c.BindJSON(&request); err != nil || request.RefreshToken == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	family, err := tokens.Check(c.Request.Context(), request.RefreshToken)
+	if errors.Is(err, tokens.ErrReused) {
+		revokeReused(c, family)
+		return
+	}
+	if errors.Is(err, tokens.ErrInvalid) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		return
+	}
+
+	// Only active sessions may be renewed
+	session, err := sessions.Get(c.Request.Context(), family.ID)
+	if err != nil && !errors.Is(err, sessions.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		return
+	}
+	if err != nil || session.Status != models.SessionStatusActive {
+		rejectFamily(c, family)
+		return
+	}
+	if sessions.Expired(session) {
+		sessions.Expire(c.Request.Context(), family.ID)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired"})
+		return
+	}
+
+	// Refreshing counts as activity on the session
+	if err := sessions.Touch(c.Request.Context(), session, c.ClientIP()); err != nil {
+		log.Printf("Failed to update session last-seen: %v", err)
+	}
+
+	// Look the user up again so deleted accounts cannot keep refreshing
+	var userID string
+	err = db.DB.Get(&userID, "SELECT id FROM users WHERE mobile = $1", family.Mobile)
+	if errors.Is(err, sql.ErrNoRows) {
+		rejectFamily(c, family)
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		return
+	}
+
+	token, err := utils.GenerateJWT(utils.TokenSubject{
+		UserID:    userID,
+		Mobile:    family.Mobile,
+		SessionID: family.ID,
+		DeviceID:  family.Device,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	// Keep the device's token mapping current so logout-all can still find it
+	tokenKey := cache.DeviceTokenKey(family.Mobile, family.Device)
+	if err := cache.RDB.Set(context.Background(), tokenKey, token, utils.AccessTokenTTL).Err(); err != nil {
+		log.Printf("Failed to store the access token of session %s: %v", family.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		return
+	}
+
+	// Use up the refresh token last, so a failure before this point can be retried with it
+	refreshToken, err := tokens.Rotate(c.Request.Context(), request.RefreshToken, family)
+	if errors.Is(err, tokens.ErrReused) {
+		revokeReused(c, family)
+		return
+	}
+	if errors.Is(err, tokens.ErrInvalid) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":         token,
+		"refresh_token": refreshToken,
+		"expires_in":    int(utils.AccessTokenTTL.Seconds()),
+	})
+}
+
+// revokeReused ends the login of a refresh token that was used twice: the token was stolen or
+// replayed, so its access tokens must stop working too. A failed revocation is reported as a
+// server error rather than a plain rejection.
+func revokeReused(c *gin.Context, family *tokens.Family) {
+	if err := sessions.RevokeCompromised(c.Request.Context(), family); err != nil {
+		log.Printf("Failed to revoke session %s after refresh token reuse: %v", family.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke compromised session"})
+		return
+	}
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token reuse detected. Please log in again."})
+}
+
+// rejectFamily revokes a refresh token family whose session or user is gone and rejects the request
+func rejectFamily(c *gin.Context, family *tokens.Family) {
+	if err := tokens.RevokeFamily(c.Request.Context(), family.ID); err != nil {
+		log.Printf("Failed to revoke refresh token family %s: %v", family.ID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh token"})
+		return
+	}
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired refresh token"})
+}
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"otp-auth-system/db"
+	"otp-auth-system/models"
+	"otp-auth-system/ratelimit"
+	"otp-auth-system/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TrustedLoginRequest defines the request body for logging in from a trusted device
+type TrustedLoginRequest struct {
+	DeviceCredential string `json:"device_credential"`
+	DeviceSecret     string `json:"device_secret"`
+}
+
+// TrustedLogin logs in from a trusted device without an OTP
+// @Summary Log in from a trusted device
+// @Description Exchanges a device credential from /verify for tokens. The request must come from the device the credential was issued to (X-Device-ID) and carry the device secret issued with it. Returns 403 with otp_required when trusted devices are disabled or the user's policy or risk signals require an OTP.
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param X-Device-ID header string true "Device ID"
+// @Param request body handlers.TrustedLoginRequest true "Device credential and secret"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 403 {object} map[string]interface{}
+// @Failure 429 {object} map[string]interface{}
+// @Failure 500 {object} map[string]string
+// @Router /auth/trusted [post]
+func TrustedLogin(c *gin.Context) {
+	var request struct {
+		DeviceCredential string `json:"device_credential"`
+		DeviceSecret     string `json:"device_secret"`
+	}
+
+	if utils.TrustedDeviceTTL == 0 {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Trusted devices are disabled", "otp_required": true, "reason": "disabled"})
+		return
+	}
+
+	if err := c.BindJSON(&request); err != nil || request.DeviceCredential == "" || request.DeviceSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	// Count every attempt, by the credential's number when it has one, so secrets cannot be guessed
+	claims, err := utils.ValidateDeviceCredential(request.DeviceCredential)
+	mobile := ""
+	if err == nil {
+		mobile = claims.Mobile
+	}
+	if rateLimited(c, ratelimit.Trusted, mobile) {
+		return
+	}
+
+	// The credential only works on the device it was issued to
+	if err != nil || claims.DeviceID != c.GetHeader("X-Device-ID") {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired device credential"})
+		return
+	}
+
+	// The device must still be registered and trusted with this credential
+	var trusted struct {
+		models.Device
+		RequireOTP bool   `db:"require_otp"`
+		Status     string `db:"status"`
+	}
+	err = db.DB.Get(&trusted, `SELECT d.*, u.require_otp, u.status FROM user_devices d JOIN users u ON u.mobile = d.mobile
+		WHERE d.mobile = $1 AND d.device_id = $2`, claims.Mobile, claims.DeviceID)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Device is not trusted"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if trusted.Status != models.UserStatusActive || trusted.TrustID == nil || *trusted.TrustID != claims.ID {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Device is not trusted"})
+		return
+	}
+
+	// The device proves it holds the secret issued with the credential, which never travels inside it
+	if trusted.TrustSecret == nil || !utils.DeviceSecretMatches(request.DeviceSecret, *trusted.TrustSecret) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired device credential"})
+		return
+	}
+
+	// Admin policy and risk signals can still demand an OTP
+	reason := ""
+	if trusted.RequireOTP {
+		reason = "policy"
+	} else {
+		reason = deviceRisk(c, &trusted.Device)
+	}
+	if reason != "" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "OTP verification required", "otp_required": true, "reason": reason})
+		return
+	}
+
+	response := issueLoginToken(c, claims.Mobile)
+	if response == nil {
+		return
+	}
+
+	response["message"] = "Trusted device login successful"
+	c.JSON(http.StatusOK, response)
+}
+
+// trustDevice issues a device credential and secret for the device in a login response and records
+// the trust on the device. Users whose policy requires OTPs get no credential. It writes the error response and
+// returns false on failure.
+func trustDevice(c *gin.Context, mobile string, response gin.H) bool {
+	if utils.TrustedDeviceTTL == 0 {
+		return true
+	}
+
+	var user models.User
+	if err := db.DB.Get(&user, "SELECT id, require_otp FROM users WHERE mobile = $1", mobile); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load user"})
+		return false
+	}
+	if user.RequireOTP {
+		return true
+	}
+
+	deviceID := response["device_id"].(string)
+	credential, claims, err := utils.GenerateDeviceCredential(user.ID.String(), mobile, deviceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to trust device"})
+		return false
+	}
+
+	secret, secretHash, err := utils.NewDeviceSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to trust device"})
+		return false
+	}
+
+	_, err = db.DB.Exec(`UPDATE user_devices SET trust_id = $1, trusted_until = $2, trust_secret_hash = $3
+		WHERE mobile = $4 AND device_id = $5`, claims.ID, claims.ExpiresAt.Time, secretHash, mobile, deviceID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to trust device"})
+		return false
+	}
+
+	response["device_credential"] = credential
+	response["device_secret"] = secret
+	response["trusted_until"] = claims.ExpiresAt.Time
+	return true
+}
+
+// deviceRisk compares a trusted-device login with what is known about the device and returns
+// why an OTP is needed, or "" when the login looks like the same device in the same place. Once a
+// device's country is known, a login whose country cannot be determined is treated as risky.
+func deviceRisk(c *gin.Context, device *models.Device) string {
+	ua := utils.ParseUserAgent(c.Request.UserAgent())
+	if device.OS != "" && ua.OS != device.OS {
+		return "device_changed"
+	}
+
+	if device.Location != "" {
+		country := requestCountry(c)
+		if country == "" {
+			return "location_unknown"
+		}
+		if country != device.Location {
+			return "location_changed"
+		}
+	}
+
+	return ""
+}
+
+// OTPPolicyRequest defines the request body for setting a user's OTP policy
+type OTPPolicyRequest struct {
+	RequireOTP bool `json:"require_otp"`
+}
+
+// SetOTPPolicy sets whether a user must always log in with an OTP
+// @Summary Set a user's OTP policy
+// @Description With require_otp, the user's trusted devices are withdrawn and no new ones can be trusted until the policy is lifted
+// @Tags Internal
+// @Accept json
+// @Produce json
+// @Param X-Internal-Token header string true "Internal API token"
+// @Param mobile path string true "User's mobile number"
+// @Param request body handlers.OTPPolicyRequest true "Policy"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 403 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Failure 500 {object} map[string]string
+// @Router /internal/users/{mobile}/otp-policy [put]
+func SetOTPPolicy(c *gin.Context) {
+	var request struct {
+		RequireOTP bool `json:"require_otp"`
+	}
+
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	mobile := c.Param("mobile")
+	if !validMobile(c, mobile) {
+		return
+	}
+	result, err := db.DB.Exec("UPDATE users SET require_otp = $1 WHERE mobile = $2", request.RequireOTP, mobile)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update policy"})
+		return
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return
+	}
+
+	// Withdraw existing trust so lifting the policy later does not revive old credentials
+	if request.RequireOTP {
+		_, err := db.DB.Exec("UPDATE user_devices SET trust_id = NULL, trusted_until = NULL, trust_secret_hash = NULL WHERE mobile = $1", mobile)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to withdraw trusted devices"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "OTP policy updated", "require_otp": request.RequireOTP})
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"otp-auth-system/models"
+	"otp-auth-system/ratelimit"
+	"otp-auth-system/utils"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDeviceRisk(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const userAgent = "Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Mobile Safari/537.36"
+	deviceOS := utils.ParseUserAgent(userAgent).OS
+
+	tests := []struct {
+		name          string
+		location      string
+		country       string
+		viaCloudflare bool
+		want          string
+	}{
+		{"same country", "IN", "IN", true, ""},
+		{"different country", "IN", "US", true, "location_changed"},
+		{"missing country", "IN", "", true, "location_unknown"},
+		{"country not from cloudflare", "IN", "IN", false, "location_unknown"},
+		{"device location never known", "", "", false, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodPost, "/auth/trusted", nil)
+			c.Request.Header.Set("User-Agent", userAgent)
+			if test.country != "" {
+				c.Request.Header.Set("CF-IPCountry", test.country)
+			}
+			c.Set("via_cloudflare", test.viaCloudflare)
+
+			got := deviceRisk(c, &models.Device{OS: deviceOS, Location: test.location})
+			if got != test.want {
+				t.Errorf("deviceRisk() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDeviceSecretMatches(t *testing.T) {
+	secret, hash, err := utils.NewDeviceSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !utils.DeviceSecretMatches(secret, hash) {
+		t.Error("issued secret does not match its hash")
+	}
+	if utils.DeviceSecretMatches(secret[1:], hash) || utils.DeviceSecretMatches("", hash) {
+		t.Error("wrong secret matches")
+	}
+}
+
+func TestTrustedLoginDisabled(t *testing.T) {
+	s := newTestServer(t)
+	s.router.POST("/auth/trusted", TrustedLogin)
+
+	ttl := utils.TrustedDeviceTTL
+	utils.TrustedDeviceTTL = 0
+	t.Cleanup(func() { utils.TrustedDeviceTTL = ttl })
+
+	rec := s.do(http.MethodPost, "/auth/trusted", "", gin.H{"device_credential": "credential", "device_secret": "secret"})
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("trusted login while disabled: got %d %s", rec.Code, rec.Body)
+	}
+}
+
+func TestTrustedLoginIsRateLimited(t *testing.T) {
+	s := newTestServer(t)
+	s.router.POST("/auth/trusted", TrustedLogin)
+
+	limit := ratelimit.Trusted.Rules[ratelimit.IP].Limit
+	for i := 0; i < limit; i++ {
+		rec := s.do(http.MethodPost, "/auth/trusted", "", gin.H{"device_credential": "forged", "device_secret": "guess"})
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: got %d %s", i+1, rec.Code, rec.Body)
+		}
+	}
+	rec := s.do(http.MethodPost, "/auth/trusted", "", gin.H{"device_credential": "forged", "device_secret": "guess"})
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("attempt over the limit: got %d %s", rec.Code, rec.Body)
+	}
+}
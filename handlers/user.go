@@ -8,7 +8,7 @@ import (
 
 // GetCurrentUser retrieves user details from the JWT token
 // @Summary Get current user details
-// @Description Returns the authenticated user's mobile number
+// @Description Returns the authenticated user's ID and mobile number
 // @Tags User
 // @Security BearerToken
 // @Accept json
@@ -25,6 +25,7 @@ func GetCurrentUser(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "User details retrieved successfully",
+		"id":      c.GetString("user_id"),
 		"mobile":  mobile,
 	})
 }
@@ -2,37 +2,99 @@ package handlers
 
 import (
 	"context"
-	"database/sql"
 	"errors"
-	"fmt"
 	"net/http"
 	"otp-auth-system/cache"
 	"otp-auth-system/db"
+	"otp-auth-system/models"
+	"otp-auth-system/otp"
+	"otp-auth-system/ratelimit"
+	"otp-auth-system/sessions"
+	"otp-auth-system/tokens"
 	"otp-auth-system/utils"
-	"time"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 )
 
 // VerifyOTPRequest defines the request body for verifying OTP
 type VerifyOTPRequest struct {
-	Mobile string `json:"mobile"`
-	OTP    string `json:"otp"`
+	Mobile      string `json:"mobile"`
+	OTP         string `json:"otp"`
+	TrustDevice bool   `json:"trust_device"`
 }
 
 // VerifyOTP verifies OTP for authentication
 // @Summary Verify OTP
-// @Description Confirms OTP and authenticates user
+// @Description Confirms OTP and authenticates user. With trust_device, the response also carries a device credential for logging in without an OTP at /auth/trusted.
 // @Tags Authentication
 // @Accept json
 // @Produce json
-// @Param request body handlers.VerifyOTPRequest true "User's mobile number and OTP"
-// @Success 200 {object} map[string]string
+// @Param request body handlers.VerifyOTPRequest true "User's mobile number and OTP, and whether to trust the device"
+// @Success 200 {object} map[string]interface{}
 // @Failure 400 {object} map[string]string
-// @Failure 401 {object} map[string]string
+// @Failure 401 {object} map[string]interface{}
+// @Failure 429 {object} map[string]interface{}
 // @Failure 500 {object} map[string]string
 // @Router /verify [post]
 func VerifyOTP(c *gin.Context) {
+	var request struct {
+		Mobile      string `json:"mobile"`
+		OTP         string `json:"otp"`
+		TrustDevice bool   `json:"trust_device"`
+	}
+
+	if err := c.BindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	if !validMobile(c, request.Mobile) {
+		return
+	}
+
+	if rateLimited(c, ratelimit.Verify, request.Mobile) {
+		return
+	}
+
+	// Verify OTP against its hashed record; only login OTPs are accepted and a correct OTP is consumed
+	if err := otp.Verify(c.Request.Context(), request.Mobile, request.OTP, otp.Scope{Purpose: otp.PurposeLogin}); err != nil {
+		respondOTPError(c, err)
+		return
+	}
+
+	recordVerified(c, request.Mobile)
+
+	response := issueLoginToken(c, request.Mobile)
+	if response == nil {
+		return
+	}
+
+	// Trust the device if asked, unless trusted devices are disabled for the user
+	if request.TrustDevice && !trustDevice(c, request.Mobile, response) {
+		return
+	}
+
+	response["message"] = "OTP verified, login successful"
+	c.JSON(http.StatusOK, response)
+}
+
+// VerifyRegistration completes a registration by verifying its OTP
+// @Summary Verify registration OTP
+// @Description Confirms the registration OTP, activates the user and logs them in
+// @Tags Authentication
+// @Accept json
+// @Produce json
+// @Param request body handlers.VerifyOTPRequest true "User's mobile number and OTP"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]interface{}
+// @Failure 404 {object} map[string]string
+// @Failure 429 {object} map[string]interface{}
+// @Failure 500 {object} map[string]string
+// @Router /register/verify [post]
+func VerifyRegistration(c *gin.Context) {
 	var request struct {
 		Mobile string `json:"mobile"`
 		OTP    string `json:"otp"`
@@ -43,57 +105,191 @@ func VerifyOTP(c *gin.Context) {
 		return
 	}
 
-	// Retrieve OTP from Redis
-	storedOTP, err := cache.RDB.Get(context.Background(), request.Mobile).Result()
-	if err != nil || storedOTP != request.OTP {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired OTP"})
+	if !validMobile(c, request.Mobile) {
 		return
 	}
 
-	// OTP is correct, remove it from Redis
-	cache.RDB.Del(context.Background(), request.Mobile)
+	if rateLimited(c, ratelimit.Verify, request.Mobile) {
+		return
+	}
 
-	// Generate JWT token
-	token, err := utils.GenerateJWT(request.Mobile)
+	// Check for a pending registration before spending an OTP attempt
+	var pending bool
+	err := db.DB.Get(&pending, "SELECT EXISTS(SELECT 1 FROM users WHERE mobile = $1 AND status = $2)", request.Mobile, models.UserStatusPending)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !pending {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No pending registration found"})
 		return
 	}
 
-	// Generate current fingerprint
-	currentFingerprint := utils.GenerateFingerprint(c.Request)
+	// Only registration OTPs are accepted
+	if err := otp.Verify(c.Request.Context(), request.Mobile, request.OTP, otp.Scope{Purpose: otp.PurposeRegister}); err != nil {
+		respondOTPError(c, err)
+		return
+	}
 
-	// Check if fingerprint already exists
-	var existingFingerprint string
-	err = db.DB.Get(&existingFingerprint, "SELECT device_fingerprint FROM user_devices WHERE mobile = $1 AND device_fingerprint = $2", request.Mobile, currentFingerprint)
+	recordVerified(c, request.Mobile)
 
-	// Determine which fingerprint to use for JWT storage
-	var fingerprintToUse string
+	// Activate the user now that the number is verified
+	result, err := db.DB.Exec("UPDATE users SET status = $1, verified_at = NOW() WHERE mobile = $2 AND status = $3",
+		models.UserStatusActive, request.Mobile, models.UserStatusPending)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to activate user"})
+		return
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No pending registration found"})
+		return
+	}
 
-	if err == nil {
-		// ✅ Existing fingerprint found → Use it
-		fingerprintToUse = existingFingerprint
-	} else if errors.Is(err, sql.ErrNoRows) {
-		// ❌ No fingerprint found → Store the new fingerprint
-		fingerprintToUse = currentFingerprint
-		_, err := db.DB.Exec("INSERT INTO user_devices (mobile, device_fingerprint) VALUES ($1, $2)", request.Mobile, currentFingerprint)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store new device fingerprint"})
-			return
-		}
-	} else {
-		// ⚠️ Unexpected database error
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error while checking device fingerprint"})
+	response := issueLoginToken(c, request.Mobile)
+	if response == nil {
 		return
 	}
 
-	// 🔐 Store JWT token in Redis mapped to the chosen fingerprint
-	tokenKey := fmt.Sprintf("device_token:%s:%s", request.Mobile, fingerprintToUse)
-	err = cache.RDB.Set(context.Background(), tokenKey, token, 24*time.Hour).Err()
+	response["message"] = "Registration complete, login successful"
+	c.JSON(http.StatusOK, response)
+}
+
+// issueLoginToken generates an access token and a refresh token for the user and binds them to
+// the requesting device. It writes the error response and returns nil on failure.
+func issueLoginToken(c *gin.Context, mobile string) gin.H {
+	// Tokens identify the user by ID rather than phone number
+	var userID string
+	if err := db.DB.Get(&userID, "SELECT id FROM users WHERE mobile = $1", mobile); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load user"})
+		return nil
+	}
+	userUUID, err := uuid.Parse(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load user"})
+		return nil
+	}
+
+	// Identify the device by the ID it was issued at an earlier login, or issue a new one
+	deviceID, err := resolveDevice(c, mobile)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register device"})
+		return nil
+	}
+
+	// Start a refresh token family for this login; its ID identifies the session in access tokens
+	refreshToken, family, err := tokens.Issue(c.Request.Context(), mobile, deviceID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store token"})
-		return
+		return nil
+	}
+	sessionID, err := uuid.Parse(family.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return nil
+	}
+
+	// Record the session server-side so it can be listed and revoked
+	err = sessions.Create(c.Request.Context(), &models.Session{
+		ID:        sessionID,
+		UserID:    userUUID,
+		Mobile:    mobile,
+		DeviceID:  deviceID,
+		IP:        c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create session"})
+		return nil
+	}
+
+	// Generate JWT token
+	token, err := utils.GenerateJWT(utils.TokenSubject{
+		UserID:    userID,
+		Mobile:    mobile,
+		SessionID: family.ID,
+		DeviceID:  deviceID,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return nil
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "OTP verified, login successful", "token": token})
+	// 🔐 Store JWT token in Redis mapped to the device
+	tokenKey := cache.DeviceTokenKey(mobile, deviceID)
+	err = cache.RDB.Set(context.Background(), tokenKey, token, utils.AccessTokenTTL).Err()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store token"})
+		return nil
+	}
+
+	return gin.H{
+		"token":         token,
+		"refresh_token": refreshToken,
+		"expires_in":    int(utils.AccessTokenTTL.Seconds()),
+		"device_id":     deviceID,
+	}
+}
+
+// resolveDevice returns the device ID for a login. Clients send the ID they were given at
+// their first login in the X-Device-ID header; unknown or missing IDs get a new device.
+// The request fingerprint is stored with the device as a risk signal, not as its identity.
+func resolveDevice(c *gin.Context, mobile string) (string, error) {
+	fingerprint := utils.GenerateFingerprint(c.Request)
+	ua := utils.ParseUserAgent(c.Request.UserAgent())
+	appVersion := c.GetHeader("X-App-Version")
+	location := requestCountry(c)
+
+	if deviceID := c.GetHeader("X-Device-ID"); deviceID != "" {
+		result, err := db.DB.Exec(`UPDATE user_devices SET device_fingerprint = $1, os = $2, browser = $3, app_version = $4,
+			last_seen_at = NOW(), last_ip = $5, location = $6 WHERE mobile = $7 AND device_id = $8`,
+			fingerprint, ua.OS, ua.Browser, appVersion, c.ClientIP(), location, mobile, deviceID)
+		if err != nil {
+			return "", err
+		}
+		if rowsAffected, _ := result.RowsAffected(); rowsAffected > 0 {
+			return deviceID, nil
+		}
+	}
+
+	deviceID := uuid.NewString()
+	_, err := db.DB.Exec(`INSERT INTO user_devices (mobile, device_id, device_fingerprint, name, os, browser, app_version, last_ip, location)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		mobile, deviceID, fingerprint, ua.DeviceName(), ua.OS, ua.Browser, appVersion, c.ClientIP(), location)
+	return deviceID, err
+}
+
+// requestCountry returns the country of the client as reported by Cloudflare's CF-IPCountry header.
+// The header is ignored unless the request was relayed by Cloudflare, since clients can set it.
+func requestCountry(c *gin.Context) string {
+	if !c.GetBool("via_cloudflare") {
+		return ""
+	}
+	country := strings.ToUpper(c.GetHeader("CF-IPCountry"))
+	if country == "XX" || country == "T1" {
+		return "" // Unknown, or a Tor exit node
+	}
+	return country
+}
+
+// respondOTPError writes the response for a failed OTP verification, including the
+// remaining attempts or the time until the client may retry
+func respondOTPError(c *gin.Context, err error) {
+	var verifyErr *otp.VerifyError
+	errors.As(err, &verifyErr)
+
+	switch {
+	case errors.Is(err, otp.ErrLocked):
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error":       "Too many failed attempts. Try again later.",
+			"retry_after": setRetryAfter(c, verifyErr.RetryAfter),
+		})
+	case errors.Is(err, otp.ErrInvalid):
+		response := gin.H{"error": "Invalid or expired OTP"}
+		if verifyErr != nil {
+			response["attempts_remaining"] = verifyErr.AttemptsRemaining
+		}
+		c.JSON(http.StatusUnauthorized, response)
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify OTP"})
+	}
 }
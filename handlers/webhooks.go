@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"otp-auth-system/delivery"
+	"otp-auth-system/sms"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxWebhookBody caps the size of delivery report payloads
+const maxWebhookBody = 1 << 20
+
+// HandleSMSWebhook ingests delivery reports (DLRs) posted by SMS providers
+// @Summary SMS delivery report webhook
+// @Description Accepts signed delivery reports from an SMS provider and updates OTP delivery status
+// @Tags Webhooks
+// @Accept json
+// @Accept x-www-form-urlencoded
+// @Produce json
+// @Param provider path string true "Provider name (fast2sms, twilio, msg91, sink)"
+// @Success 200 {object} map[string]interface{}
+// @Failure 400 {object} map[string]string
+// @Failure 401 {object} map[string]string
+// @Failure 404 {object} map[string]string
+// @Router /webhooks/sms/{provider} [post]
+func HandleSMSWebhook(c *gin.Context) {
+	name := c.Param("provider")
+
+	provider, ok := sms.Lookup(SMSProvider, name)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown SMS provider"})
+		return
+	}
+	parser, ok := provider.(sms.ReceiptParser)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Provider does not support delivery reports"})
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxWebhookBody))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid input"})
+		return
+	}
+
+	receipts, err := parser.ParseReceipts(c.Request, body)
+	if errors.Is(err, sms.ErrInvalidSignature) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid delivery report"})
+		return
+	}
+
+	for _, receipt := range receipts {
+		if err := delivery.RecordReceipt(c.Request.Context(), name, receipt); err != nil {
+			log.Printf("Failed to record %s delivery report for %s: %v", name, receipt.MessageID, err)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"processed": len(receipts)})
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"github.com/jmoiron/sqlx"
 	"github.com/redis/go-redis/v9"
@@ -17,8 +18,16 @@ import (
 
 	"otp-auth-system/cache"
 	"otp-auth-system/db"
+	"otp-auth-system/delivery"
+	"otp-auth-system/fraud"
 	"otp-auth-system/handlers"
 	"otp-auth-system/middleware"
+	"otp-auth-system/otp"
+	"otp-auth-system/ratelimit"
+	"otp-auth-system/sessions"
+	"otp-auth-system/sms"
+	"otp-auth-system/tokens"
+	"otp-auth-system/utils"
 
 	_ "otp-auth-system/docs" // Import Swagger Docs
 )
@@ -37,6 +46,19 @@ func main() {
 		log.Println("No .env file found, using default values")
 	}
 
+	// Admin command: create a new signing key in JWT_KEYS_DIR and exit
+	if len(os.Args) > 1 && os.Args[1] == "rotate-keys" {
+		if err := utils.InitJWT(); err != nil {
+			log.Println("Signing keys not loaded:", err)
+		}
+		kid, err := utils.RotateKeys()
+		if err != nil {
+			log.Fatalf("Failed to rotate signing keys: %v", err)
+		}
+		fmt.Printf("Created signing key %s\n", kid)
+		return
+	}
+
 	// Determine environment mode
 	env := os.Getenv("ENV")
 	if env == "" {
@@ -65,11 +87,65 @@ func main() {
 		}
 	}(cache.RDB)
 
-	// Periodically clean up expired tokens
+	// Configure OTP generation
+	if err := utils.InitOTPGenerator(); err != nil {
+		log.Fatalf("Invalid OTP configuration: %v", err)
+	}
+	if err := otp.Init(); err != nil {
+		log.Fatalf("Invalid OTP configuration: %v", err)
+	}
+	if err := ratelimit.Init(); err != nil {
+		log.Fatalf("Invalid rate limit configuration: %v", err)
+	}
+	if err := fraud.Init(); err != nil {
+		log.Fatalf("Invalid fraud configuration: %v", err)
+	}
+	if err := middleware.InitClientIP(); err != nil {
+		log.Fatalf("Invalid proxy configuration: %v", err)
+	}
+
+	// Configure token signing and lifetimes
+	if err := utils.InitJWT(); err != nil {
+		log.Fatalf("Invalid JWT configuration: %v", err)
+	}
+	utils.StartKeyRotation()
+	tokens.Init()
+	sessions.Init()
+	sessions.StartSweeper()
+
+	// Initialize SMS provider
+	smsProvider, err := sms.NewProviderFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure SMS provider: %v", err)
+	}
+	handlers.SMSProvider = smsProvider
+	fmt.Printf("Sending OTPs via %s\n", smsProvider.Name())
+
+	// Initialize the voice provider offered once SMS resends run out
+	voiceProvider, err := sms.NewVoiceProviderFromEnv()
+	if err != nil {
+		log.Fatalf("Failed to configure voice provider: %v", err)
+	}
+	if voiceProvider != nil {
+		handlers.VoiceProvider = voiceProvider
+		fmt.Printf("Offering OTP calls via %s\n", voiceProvider.Name())
+	}
+
+	// Start OTP delivery workers
+	if err := delivery.Init(); err != nil {
+		log.Fatalf("Invalid delivery configuration: %v", err)
+	}
+	delivery.StartWorkers(context.Background(), smsProvider, voiceProvider)
+
+	// Periodically clean up unverified registrations
+	registrationTTL, err := time.ParseDuration(os.Getenv("REGISTRATION_PENDING_TTL"))
+	if err != nil || registrationTTL <= 0 {
+		registrationTTL = 24 * time.Hour
+	}
 	go func() {
 		for {
 			time.Sleep(1 * time.Hour) // Run every hour
-			cache.RemoveExpiredTokens()
+			db.RemoveExpiredRegistrations(registrationTTL)
 		}
 	}()
 
@@ -79,18 +155,19 @@ func main() {
 	// Enable CORS for all origins
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"}, // Allow all origins
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Authorization", "Content-Type"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Authorization", "Content-Type", "X-Device-ID", "X-App-Version", "X-Challenge-Token"},
+		ExposeHeaders:    []string{"Content-Length", "RateLimit-Limit", "RateLimit-Remaining", "RateLimit-Reset", "Retry-After"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
 
-	// Restrict Trusted Proxies
-	err := router.SetTrustedProxies(nil)
+	// Restrict Trusted Proxies; ClientIPMiddleware resolves the client behind our own proxies instead
+	err = router.SetTrustedProxies(nil)
 	if err != nil {
 		log.Fatalf("Error Restricted Proxies: %v", err)
 	}
+	router.Use(middleware.ClientIPMiddleware())
 
 	// Enable Swagger UI only in non-production environments
 	if env != "production" {
@@ -98,16 +175,40 @@ func main() {
 	}
 
 	// Routes
-	router.POST("/register", handlers.RegisterUser) // Register new user
-	router.POST("/login", handlers.LoginUser)       // Generate OTP for login
-	router.POST("/verify", handlers.VerifyOTP)      // Verify OTP and authenticate user
+	router.POST("/register", handlers.RegisterUser)              // Start registration, sends OTP
+	router.POST("/register/verify", handlers.VerifyRegistration) // Verify registration OTP and activate user
+	router.POST("/login", handlers.LoginUser)                    // Generate OTP for login
+	router.POST("/verify", handlers.VerifyOTP)                   // Verify OTP and authenticate user
 	router.POST("/resend-otp", handlers.ResendOTP)
+	router.POST("/auth/start", handlers.StartAuth)                 // Send OTP to any number and return a challenge ID
+	router.POST("/auth/complete", handlers.CompleteAuth)           // Verify challenge OTP and issue JWT
+	router.POST("/auth/trusted", handlers.TrustedLogin)            // Log in from a trusted device without OTP
+	router.GET("/otp/delivery/:id", handlers.GetOTPDeliveryStatus) // Poll OTP delivery status
+	router.POST("/token/refresh", handlers.RefreshToken)           // Rotate refresh token and issue new access token
+
+	router.GET("/.well-known/jwks.json", handlers.GetJWKS) // Public keys for verifying access tokens
+
+	// Webhook Routes (Authenticated by provider signature)
+	router.POST("/webhooks/sms/:provider", handlers.HandleSMSWebhook) // SMS delivery reports
+
+	// Internal Routes (Require INTERNAL_API_TOKEN)
+	internal := router.Group("/internal").Use(middleware.InternalAuthMiddleware())
+
+	internal.GET("/sms/health", handlers.GetSMSHealth)               // SMS provider health and circuit state
+	internal.GET("/sms/carriers", handlers.GetCarrierMetrics)        // Delivery rates per carrier
+	internal.GET("/keys", handlers.GetSigningKeys)                   // Signing keys and their status
+	internal.POST("/keys/rotate", handlers.RotateSigningKeys)        // Create a new signing key
+	internal.PUT("/users/:mobile/otp-policy", handlers.SetOTPPolicy) // Require OTPs for a user
+	internal.GET("/fraud/decisions", handlers.GetFraudDecisions)     // Recent fraud decisions on OTP sends
 
 	// Protected Route (Requires JWT)
 	protected := router.Group("/").Use(middleware.AuthMiddleware())
 
 	protected.GET("/user", handlers.GetCurrentUser)                  // Get logged-in user details
 	protected.GET("/user/devices", handlers.GetRegisteredDevices)    // Get logged-in user details
+	protected.PATCH("/user/devices/:id", handlers.RenameDevice)      // Rename a device
+	protected.GET("/user/sessions", handlers.GetSessions)            // List active sessions
+	protected.DELETE("/user/sessions/:id", handlers.RevokeSession)   // Revoke a session
 	protected.DELETE("/device", handlers.RemoveRegisteredDevice)     // Remove a specific device
 	protected.DELETE("/devices/all", handlers.RemoveAllOtherDevices) // Remove all devices except current one
 	protected.POST("/logout", handlers.Logout)                       // Logout from current device
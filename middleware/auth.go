@@ -1,9 +1,12 @@
 package middleware
 
 import (
-	"fmt"
+	"errors"
+	"log"
 	"net/http"
 	"otp-auth-system/cache"
+	"otp-auth-system/models"
+	"otp-auth-system/sessions"
 	"otp-auth-system/utils"
 	"strings"
 
@@ -23,24 +26,56 @@ func AuthMiddleware() gin.HandlerFunc {
 		// Extract token from "Bearer <token>"
 		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 
-		// Check if token is blacklisted
-		isBlacklisted, _ := cache.IsTokenBlacklisted(tokenString)
-		if isBlacklisted {
-			fmt.Println("🚨 Blacklisted token detected:", tokenString) // Debugging log
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token is invalid or expired"})
+		claims, err := utils.ValidateJWT(tokenString)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
 			c.Abort()
 			return
 		}
 
-		claims, err := utils.ValidateJWT(tokenString)
+		// Check if token has been revoked
+		isRevoked, err := cache.IsTokenRevoked(claims.ID)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate token"})
+			c.Abort()
+			return
+		}
+		if isRevoked {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Token is invalid or expired"})
+			c.Abort()
+			return
+		}
+
+		// The session must still be active; revoking it invalidates every token issued to it
+		session, err := sessions.Get(c.Request.Context(), claims.SessionID)
+		if err != nil && !errors.Is(err, sessions.ErrNotFound) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to validate token"})
+			c.Abort()
+			return
+		}
+		if err != nil || session.Status != models.SessionStatusActive {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has been revoked"})
 			c.Abort()
 			return
 		}
+		if sessions.Expired(session) {
+			sessions.Expire(c.Request.Context(), claims.SessionID)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session expired"})
+			c.Abort()
+			return
+		}
+
+		// Keep the session alive; failing to record activity does not fail the request
+		if err := sessions.Touch(c.Request.Context(), session, c.ClientIP()); err != nil {
+			log.Printf("Failed to update session last-seen: %v", err)
+		}
 
 		// Store user information in the request context
 		c.Set("mobile", claims.Mobile)
+		c.Set("user_id", claims.Subject)
+		c.Set("session_id", claims.SessionID)
+		c.Set("device_id", claims.DeviceID)
+		c.Set("claims", claims)
 
 		c.Next()
 	}
@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cloudflareRanges are Cloudflare's published edge addresses (https://www.cloudflare.com/ips/)
+var cloudflareRanges = []string{
+	"173.245.48.0/20", "103.21.244.0/22", "103.22.200.0/22", "103.31.4.0/22", "141.101.64.0/18",
+	"108.162.192.0/18", "190.93.240.0/20", "188.114.96.0/20", "197.234.240.0/22", "198.41.128.0/17",
+	"162.158.0.0/15", "104.16.0.0/13", "104.24.0.0/14", "172.64.0.0/13", "131.0.72.0/22",
+	"2400:cb00::/32", "2606:4700::/32", "2803:f800::/32", "2405:b500::/32", "2405:8100::/32",
+	"2a06:98c0::/29", "2c0f:f248::/32",
+}
+
+var (
+	proxyHops          int
+	cloudflareNetworks []*net.IPNet
+)
+
+// InitClientIP loads the proxy settings used by ClientIPMiddleware:
+//   - TRUSTED_PROXY_HOPS: proxies in front of the app that append to X-Forwarded-For (default 1 on
+//     Heroku, where the router appends the address it received the request from, otherwise 0)
+//   - CLOUDFLARE_IPS: comma-separated CIDR ranges of Cloudflare's edge (default: the published list)
+func InitClientIP() error {
+	proxyHops = 0
+	if os.Getenv("DYNO") != "" {
+		proxyHops = 1
+	}
+	if value := os.Getenv("TRUSTED_PROXY_HOPS"); value != "" {
+		hops, err := strconv.Atoi(value)
+		if err != nil || hops < 0 {
+			return fmt.Errorf("invalid TRUSTED_PROXY_HOPS %q", value)
+		}
+		proxyHops = hops
+	}
+
+	ranges := cloudflareRanges
+	if value := os.Getenv("CLOUDFLARE_IPS"); value != "" {
+		ranges = strings.Split(value, ",")
+	}
+	cloudflareNetworks = nil
+	for _, entry := range ranges {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(entry))
+		if err != nil {
+			return fmt.Errorf("invalid CLOUDFLARE_IPS entry %q", entry)
+		}
+		cloudflareNetworks = append(cloudflareNetworks, network)
+	}
+	return nil
+}
+
+// ClientIPMiddleware resolves the client address behind TRUSTED_PROXY_HOPS proxies, so c.ClientIP()
+// returns it everywhere. Only the entries our own proxies appended to X-Forwarded-For are used;
+// anything further left was written by the client and is ignored. It also records whether the
+// request reached us through Cloudflare, whose CF-* headers can only be trusted then.
+func ClientIPMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// The chain runs from the client to the proxy connected to us
+		var chain []string
+		for _, header := range c.Request.Header.Values("X-Forwarded-For") {
+			for _, entry := range strings.Split(header, ",") {
+				chain = append(chain, strings.TrimSpace(entry))
+			}
+		}
+		remote, _, err := net.SplitHostPort(c.Request.RemoteAddr)
+		if err != nil {
+			remote = c.Request.RemoteAddr
+		}
+		chain = append(chain, remote)
+
+		// Each trusted proxy appended the address it received the request from
+		client := len(chain) - 1 - proxyHops
+		if client < 0 {
+			client = 0
+		}
+		if net.ParseIP(chain[client]) == nil {
+			client = len(chain) - 1 // A trusted proxy wrote garbage; fall back to the connection
+		}
+		c.Request.RemoteAddr = net.JoinHostPort(chain[client], "0")
+
+		// The hop that reported the client address is the one to its right
+		if client+1 < len(chain) {
+			c.Set("via_cloudflare", inCloudflare(chain[client+1]))
+		}
+
+		c.Next()
+	}
+}
+
+// inCloudflare reports whether the address belongs to Cloudflare's edge
+func inCloudflare(address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range cloudflareNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
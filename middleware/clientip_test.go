@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestClientIPMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name          string
+		hops          string
+		forwardedFor  string
+		remoteAddr    string
+		clientIP      string
+		viaCloudflare bool
+	}{
+		{"no proxies ignores the header", "0", "203.0.113.7", "198.51.100.1:443", "198.51.100.1", false},
+		{"router appends the client", "1", "203.0.113.7", "10.1.2.3:443", "203.0.113.7", false},
+		{"spoofed entries are ignored", "1", "1.1.1.1, 203.0.113.7", "10.1.2.3:443", "203.0.113.7", false},
+		{"missing header falls back to the connection", "1", "", "10.1.2.3:443", "10.1.2.3", false},
+		{"cloudflare in front of the router", "2", "1.1.1.1, 203.0.113.7, 172.64.1.1", "10.1.2.3:443", "203.0.113.7", true},
+		{"client posing as cloudflare", "1", "172.64.1.1", "10.1.2.3:443", "172.64.1.1", false},
+		{"garbage from a proxy", "1", "not-an-ip", "10.1.2.3:443", "10.1.2.3", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Setenv("TRUSTED_PROXY_HOPS", test.hops)
+			if err := InitClientIP(); err != nil {
+				t.Fatal(err)
+			}
+
+			router := gin.New()
+			router.SetTrustedProxies(nil)
+			router.Use(ClientIPMiddleware())
+			router.GET("/", func(c *gin.Context) {
+				if ip := c.ClientIP(); ip != test.clientIP {
+					t.Errorf("ClientIP() = %q, want %q", ip, test.clientIP)
+				}
+				if via := c.GetBool("via_cloudflare"); via != test.viaCloudflare {
+					t.Errorf("via_cloudflare = %v, want %v", via, test.viaCloudflare)
+				}
+			})
+
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.RemoteAddr = test.remoteAddr
+			if test.forwardedFor != "" {
+				request.Header.Set("X-Forwarded-For", test.forwardedFor)
+			}
+			router.ServeHTTP(httptest.NewRecorder(), request)
+		})
+	}
+}
+
+func TestInitClientIPRejectsInvalidSettings(t *testing.T) {
+	t.Setenv("TRUSTED_PROXY_HOPS", "-1")
+	if err := InitClientIP(); err == nil {
+		t.Error("negative TRUSTED_PROXY_HOPS accepted")
+	}
+
+	t.Setenv("TRUSTED_PROXY_HOPS", "1")
+	t.Setenv("CLOUDFLARE_IPS", "173.245.48.0/20,nonsense")
+	if err := InitClientIP(); err == nil {
+		t.Error("invalid CLOUDFLARE_IPS accepted")
+	}
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Device is a device a user has logged in from
+type Device struct {
+	ID           string     `db:"device_id" json:"id"`
+	Mobile       string     `db:"mobile" json:"-"`
+	Fingerprint  string     `db:"device_fingerprint" json:"device_fingerprint"` // Deprecated: only for clients that still remove devices by fingerprint
+	Name         string     `db:"name" json:"name"`
+	OS           string     `db:"os" json:"os"`
+	Browser      string     `db:"browser" json:"browser"`
+	AppVersion   string     `db:"app_version" json:"app_version"`
+	FirstSeenAt  time.Time  `db:"first_seen_at" json:"first_seen_at"`
+	LastSeenAt   time.Time  `db:"last_seen_at" json:"last_seen_at"`
+	LastIP       string     `db:"last_ip" json:"last_ip"`
+	Location     string     `db:"location" json:"location"`
+	TrustID      *string    `db:"trust_id" json:"-"`
+	TrustSecret  *string    `db:"trust_secret_hash" json:"-"`
+	TrustedUntil *time.Time `db:"trusted_until" json:"trusted_until,omitempty"`
+	Current      bool       `db:"-" json:"current"`
+}
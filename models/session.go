@@ -0,0 +1,29 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Session statuses
+const (
+	SessionStatusActive  = "active"
+	SessionStatusRevoked = "revoked"
+	SessionStatusExpired = "expired" // Reached its absolute lifetime or was idle for too long
+)
+
+// Session represents a login on a device. Its ID is the session ID (sid) carried in access tokens.
+type Session struct {
+	ID         uuid.UUID  `db:"id" json:"id"`
+	UserID     uuid.UUID  `db:"user_id" json:"user_id"`
+	Mobile     string     `db:"mobile" json:"mobile"`
+	DeviceID   string     `db:"device_id" json:"device_id"`
+	IP         string     `db:"ip" json:"ip"`
+	UserAgent  string     `db:"user_agent" json:"user_agent"`
+	Status     string     `db:"status" json:"status"`
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+	LastSeenAt time.Time  `db:"last_seen_at" json:"last_seen_at"`
+	ExpiresAt  time.Time  `db:"expires_at" json:"expires_at"`
+	RevokedAt  *time.Time `db:"revoked_at" json:"revoked_at,omitempty"`
+}
@@ -1,10 +1,24 @@
 package models
 
-import "github.com/google/uuid"
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// User statuses
+const (
+	UserStatusPending = "pending" // Registered but the mobile number is not yet verified
+	UserStatusActive  = "active"
+)
 
 // User struct represents a user in the system
 type User struct {
-	ID                uuid.UUID `db:"id" json:"id"`
-	Mobile            string    `db:"mobile" json:"mobile"`
-	DeviceFingerprint string    `db:"device_fingerprint" json:"device_fingerprint"`
+	ID                uuid.UUID  `db:"id" json:"id"`
+	Mobile            string     `db:"mobile" json:"mobile"`
+	DeviceFingerprint string     `db:"device_fingerprint" json:"device_fingerprint"`
+	Status            string     `db:"status" json:"status"`
+	CreatedAt         time.Time  `db:"created_at" json:"created_at"`
+	VerifiedAt        *time.Time `db:"verified_at" json:"verified_at,omitempty"`
+	RequireOTP        bool       `db:"require_otp" json:"require_otp"` // Set by admins to disable trusted-device logins
 }
@@ -0,0 +1,45 @@
+package otp
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+
+	"otp-auth-system/cache"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// challengePrefix namespaces authentication challenges in Redis
+const challengePrefix = "auth_challenge:"
+
+// NewChallenge creates an opaque challenge ID for an authentication attempt on the mobile number.
+// The challenge lives as long as an OTP and is used as the OTP's context, so the code is only
+// accepted together with the challenge it was sent for.
+func NewChallenge(ctx context.Context, mobile string) (string, error) {
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	id := base64.RawURLEncoding.EncodeToString(raw)
+
+	if err := cache.RDB.Set(ctx, challengePrefix+id, mobile, TTL).Err(); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// ChallengeMobile returns the mobile number a challenge was created for
+func ChallengeMobile(ctx context.Context, id string) (string, error) {
+	mobile, err := cache.RDB.Get(ctx, challengePrefix+id).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", ErrInvalid
+	}
+	return mobile, err
+}
+
+// DeleteChallenge removes a challenge once it has been completed or abandoned
+func DeleteChallenge(ctx context.Context, id string) error {
+	return cache.RDB.Del(ctx, challengePrefix+id).Err()
+}
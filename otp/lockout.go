@@ -0,0 +1,123 @@
+package otp
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"otp-auth-system/cache"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis keys tracking invalidated OTPs and lockouts per mobile number
+const (
+	invalidationPrefix = "otp_invalidations:"
+	lockoutPrefix      = "otp_lockout:"
+)
+
+// invalidationWindow is how long invalidations count towards the progressive lockout
+const invalidationWindow = 24 * time.Hour
+
+// maxLockout caps the progressive lockout
+const maxLockout = 24 * time.Hour
+
+// ErrLocked is returned while a mobile number is locked out after repeated failed verifications
+var ErrLocked = errors.New("too many failed OTP attempts")
+
+// VerifyError carries the details a client needs after a failed verification
+type VerifyError struct {
+	Err               error         // ErrInvalid or ErrLocked
+	AttemptsRemaining int           // Attempts left on the current OTP
+	RetryAfter        time.Duration // Time until a new OTP can be requested, when locked
+}
+
+func (e *VerifyError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *VerifyError) Unwrap() error {
+	return e.Err
+}
+
+// Attempt policy, read from the environment by InitLockout
+var (
+	maxAttempts = 5
+	lockoutBase = 15 * time.Minute
+)
+
+// reserveAttemptScript counts an attempt against an existing OTP record, without recreating an expired one
+var reserveAttemptScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return -1
+end
+return redis.call("HINCRBY", KEYS[1], "attempts", 1)
+`)
+
+// initLockout loads OTP_MAX_ATTEMPTS (default 5) and OTP_LOCKOUT_BASE (default 15m)
+func initLockout() {
+	if value, err := strconv.Atoi(os.Getenv("OTP_MAX_ATTEMPTS")); err == nil && value > 0 {
+		maxAttempts = value
+	}
+	if value, err := time.ParseDuration(os.Getenv("OTP_LOCKOUT_BASE")); err == nil && value > 0 {
+		lockoutBase = value
+	}
+}
+
+// LockedFor returns how long the mobile number remains locked out, or zero if it is not
+func LockedFor(ctx context.Context, mobile string) (time.Duration, error) {
+	ttl, err := cache.RDB.PTTL(ctx, lockoutPrefix+mobile).Result()
+	if err != nil {
+		return 0, err
+	}
+	if ttl < 0 {
+		return 0, nil
+	}
+	return ttl, nil
+}
+
+// reserveAttempt records an attempt against the OTP before it is compared, so concurrent
+// guesses cannot exceed the limit. It returns the number of attempts including this one.
+func reserveAttempt(ctx context.Context, key string) (int, error) {
+	attempts, err := reserveAttemptScript.Run(ctx, cache.RDB, []string{key}).Int()
+	if err != nil {
+		return 0, err
+	}
+	if attempts < 0 {
+		return 0, ErrInvalid
+	}
+	return attempts, nil
+}
+
+// invalidate deletes an exhausted OTP and applies a lockout that doubles with each repeated invalidation.
+// The first invalidation only forces a new code; from the second one on the number is locked out.
+func invalidate(ctx context.Context, mobile, key string) (time.Duration, error) {
+	var count *redis.IntCmd
+	_, err := cache.RDB.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		count = pipe.Incr(ctx, invalidationPrefix+mobile)
+		pipe.Expire(ctx, invalidationPrefix+mobile, invalidationWindow)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	invalidations := count.Val()
+	if invalidations < 2 {
+		return 0, nil
+	}
+
+	lockout := maxLockout
+	if shift := invalidations - 2; shift < 16 {
+		if d := lockoutBase << shift; d > 0 && d < maxLockout {
+			lockout = d
+		}
+	}
+	if err := cache.RDB.Set(ctx, lockoutPrefix+mobile, "1", lockout).Err(); err != nil {
+		return 0, err
+	}
+	return lockout, nil
+}
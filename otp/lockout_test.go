@@ -0,0 +1,88 @@
+package otp
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// exhaust issues an OTP and guesses wrong until it is used up, returning the last error
+func exhaust(t *testing.T) error {
+	t.Helper()
+	ctx := context.Background()
+	scope := Scope{Purpose: PurposeLogin}
+	if _, _, err := Issue(ctx, testMobile, "123456", scope); err != nil {
+		t.Fatal(err)
+	}
+
+	var err error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		err = Verify(ctx, testMobile, "654321", scope)
+		var verifyErr *VerifyError
+		if !errors.As(err, &verifyErr) {
+			t.Fatalf("attempt %d: %v, want a *VerifyError", attempt, err)
+		}
+		if attempt < maxAttempts && verifyErr.AttemptsRemaining != maxAttempts-attempt {
+			t.Errorf("attempt %d leaves %d attempts, want %d", attempt, verifyErr.AttemptsRemaining, maxAttempts-attempt)
+		}
+	}
+	return err
+}
+
+func TestVerifyLocksOutRepeatedFailures(t *testing.T) {
+	server := useMiniredis(t)
+	hmacKey = []byte("test-key")
+
+	// The first exhausted OTP is only invalidated
+	if err := exhaust(t); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("first exhausted OTP = %v, want ErrInvalid", err)
+	}
+	if server.Exists(Key(testMobile, PurposeLogin)) {
+		t.Error("exhausted OTP was not deleted")
+	}
+
+	// The second one locks the number out, even for the right code
+	err := exhaust(t)
+	var verifyErr *VerifyError
+	if !errors.As(err, &verifyErr) || !errors.Is(err, ErrLocked) || verifyErr.RetryAfter != lockoutBase {
+		t.Fatalf("second exhausted OTP = %v, want a %s lockout", err, lockoutBase)
+	}
+	if _, _, err := Issue(context.Background(), testMobile, "123456", Scope{Purpose: PurposeLogin}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(context.Background(), testMobile, "123456", Scope{Purpose: PurposeLogin}); !errors.Is(err, ErrLocked) {
+		t.Errorf("right code while locked = %v, want ErrLocked", err)
+	}
+}
+
+func TestVerifyConsumesTheOTP(t *testing.T) {
+	useMiniredis(t)
+	hmacKey = []byte("test-key")
+	ctx := context.Background()
+	scope := Scope{Purpose: PurposeLogin}
+
+	if _, _, err := Issue(ctx, testMobile, "123456", scope); err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(ctx, testMobile, "123456", Scope{Purpose: PurposeRegister}); !errors.Is(err, ErrInvalid) {
+		t.Errorf("code for another purpose = %v, want ErrInvalid", err)
+	}
+	if err := Verify(ctx, testMobile, "123456", scope); err != nil {
+		t.Fatalf("right code: %v", err)
+	}
+	if err := Verify(ctx, testMobile, "123456", scope); !errors.Is(err, ErrInvalid) {
+		t.Errorf("reused code = %v, want ErrInvalid", err)
+	}
+}
+
+func TestReserveAttemptKeepsExpiredOTPsGone(t *testing.T) {
+	server := useMiniredis(t)
+	key := Key(testMobile, PurposeLogin)
+
+	if _, err := reserveAttempt(context.Background(), key); !errors.Is(err, ErrInvalid) {
+		t.Errorf("attempt on a missing OTP = %v, want ErrInvalid", err)
+	}
+	if server.Exists(key) {
+		t.Error("attempt recreated the expired OTP")
+	}
+}
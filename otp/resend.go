@@ -0,0 +1,167 @@
+package otp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"otp-auth-system/cache"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// resendPrefix namespaces the resend state per purpose and mobile number
+const resendPrefix = "otp_resend:"
+
+// resendWindow is how long resend state is kept after the last send; the escalation starts over after it
+const resendWindow = time.Hour
+
+// Resend errors
+var (
+	ErrResendCooldown   = errors.New("OTP resend requested too soon")
+	ErrResendsExhausted = errors.New("no SMS resends left")
+	ErrVoiceNotYet      = errors.New("voice calls are offered once SMS resends are used up")
+)
+
+// ResendError carries how long a client must wait before resending
+type ResendError struct {
+	Err        error         // ErrResendCooldown, ErrResendsExhausted or ErrVoiceNotYet
+	RetryAfter time.Duration // Until the next resend, or until the resend state expires when exhausted
+}
+
+func (e *ResendError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ResendError) Unwrap() error {
+	return e.Err
+}
+
+// Resend describes the resend sequence after a send was allowed
+type Resend struct {
+	Remaining int           // SMS resends left
+	NextAfter time.Duration // Wait before the next resend is allowed
+
+	key      string
+	sentAt   int64
+	previous [2]int64 // Resends and last send time before this send, restored by Release
+}
+
+// resendIntervals is the wait before each SMS resend, configured by OTP_RESEND_INTERVALS.
+// Calls wait as long as the last SMS resend.
+var resendIntervals = []time.Duration{30 * time.Second, 60 * time.Second, 120 * time.Second}
+
+// reserveSendScript allows the first SMS of a sequence right away. Every later send is a resend,
+// allowed once the interval for the next resend has passed since the last send, and counted.
+// Voice resends are only allowed once every SMS resend is used.
+var reserveSendScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local intervals = #ARGV - 3
+local resends = tonumber(redis.call("HGET", KEYS[1], "resends")) or 0
+local last = tonumber(redis.call("HGET", KEYS[1], "last_sent_at")) or 0
+
+if ARGV[2] == "0" and last == 0 then
+	redis.call("HSET", KEYS[1], "resends", 0, "last_sent_at", now)
+	redis.call("PEXPIRE", KEYS[1], ARGV[3])
+	return {1, 0, 0, 0}
+end
+if ARGV[2] == "0" and resends >= intervals then
+	return {-1, redis.call("PTTL", KEYS[1])}
+end
+if ARGV[2] == "1" and resends < intervals then
+	return {-2, 0}
+end
+
+local wait = last + tonumber(ARGV[3 + math.min(resends + 1, intervals)]) - now
+if wait > 0 then
+	return {0, wait}
+end
+
+redis.call("HSET", KEYS[1], "resends", resends + 1, "last_sent_at", now)
+redis.call("PEXPIRE", KEYS[1], ARGV[3])
+return {1, resends + 1, resends, last}
+`)
+
+// releaseSendScript restores the resend state from before a send, unless another send followed it
+var releaseSendScript = redis.NewScript(`
+if tonumber(redis.call("HGET", KEYS[1], "last_sent_at")) ~= tonumber(ARGV[1]) then
+	return 0
+end
+if ARGV[3] == "0" then
+	redis.call("DEL", KEYS[1])
+else
+	redis.call("HSET", KEYS[1], "resends", ARGV[2], "last_sent_at", ARGV[3])
+end
+return 1
+`)
+
+// initResend loads OTP_RESEND_INTERVALS, a comma-separated list such as "30s,60s,120s"
+func initResend() error {
+	value := os.Getenv("OTP_RESEND_INTERVALS")
+	if value == "" {
+		return nil
+	}
+
+	var intervals []time.Duration
+	for _, part := range strings.Split(value, ",") {
+		interval, err := time.ParseDuration(strings.TrimSpace(part))
+		if err != nil || interval < 0 {
+			return fmt.Errorf("invalid OTP_RESEND_INTERVALS %q", value)
+		}
+		intervals = append(intervals, interval)
+	}
+	resendIntervals = intervals
+	return nil
+}
+
+// ReserveSend checks that an OTP may be sent for the purpose now and counts the send. The previous
+// OTP is not touched, so a rejected send leaves it valid. After the first SMS, SMS resends wait
+// progressively longer; once they are used up, only voice resends are allowed. Release the
+// reservation if the OTP is not sent after all.
+func ReserveSend(ctx context.Context, mobile, purpose string, voice bool) (*Resend, error) {
+	key := resendPrefix + purpose + ":" + mobile
+	now := time.Now().UnixMilli()
+	args := []interface{}{now, "0", resendWindow.Milliseconds()}
+	if voice {
+		args[1] = "1"
+	}
+	for _, interval := range resendIntervals {
+		args = append(args, interval.Milliseconds())
+	}
+
+	result, err := reserveSendScript.Run(ctx, cache.RDB, []string{key}, args...).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	switch result[0] {
+	case -1:
+		return nil, &ResendError{Err: ErrResendsExhausted, RetryAfter: time.Duration(result[1]) * time.Millisecond}
+	case -2:
+		return nil, &ResendError{Err: ErrVoiceNotYet}
+	case 0:
+		return nil, &ResendError{Err: ErrResendCooldown, RetryAfter: time.Duration(result[1]) * time.Millisecond}
+	}
+
+	resends := int(result[1])
+	next := resendIntervals[len(resendIntervals)-1]
+	if resends < len(resendIntervals) {
+		next = resendIntervals[resends]
+	}
+	return &Resend{
+		Remaining: max(0, len(resendIntervals)-resends),
+		NextAfter: next,
+		key:       key,
+		sentAt:    now,
+		previous:  [2]int64{result[2], result[3]},
+	}, nil
+}
+
+// Release gives back a reserved send whose OTP was not sent, so it does not start a wait or use up
+// a resend
+func (r *Resend) Release(ctx context.Context) error {
+	return releaseSendScript.Run(ctx, cache.RDB, []string{r.key}, r.sentAt, r.previous[0], r.previous[1]).Err()
+}
@@ -0,0 +1,126 @@
+package otp
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"otp-auth-system/cache"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+const testMobile = "+919876543210"
+
+// useMiniredis points the cache at a fresh in-memory Redis
+func useMiniredis(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	server := miniredis.RunT(t)
+	cache.RDB = redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { cache.RDB.Close() })
+	return server
+}
+
+// age moves the last send back in time, as if the wait had passed
+func age(t *testing.T, server *miniredis.Miniredis, purpose string, by time.Duration) {
+	t.Helper()
+	key := resendPrefix + purpose + ":" + testMobile
+	last, err := strconv.ParseInt(server.HGet(key, "last_sent_at"), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.HSet(key, "last_sent_at", strconv.FormatInt(last-by.Milliseconds(), 10))
+}
+
+func TestReserveSendAppliesTheWaitToEverySend(t *testing.T) {
+	server := useMiniredis(t)
+	ctx := context.Background()
+
+	resend, err := ReserveSend(ctx, testMobile, PurposeLogin, false)
+	if err != nil {
+		t.Fatalf("first send: %v", err)
+	}
+	if resend.Remaining != len(resendIntervals) || resend.NextAfter != resendIntervals[0] {
+		t.Errorf("first send = %+v, want %d resends after %s", resend, len(resendIntervals), resendIntervals[0])
+	}
+
+	// A second /login right away is a resend and has to wait
+	_, err = ReserveSend(ctx, testMobile, PurposeLogin, false)
+	var resendErr *ResendError
+	if !errors.As(err, &resendErr) || !errors.Is(err, ErrResendCooldown) || resendErr.RetryAfter <= 0 {
+		t.Fatalf("immediate second send = %v, want cooldown", err)
+	}
+
+	// Other purposes keep their own sequence
+	if _, err := ReserveSend(ctx, testMobile, PurposeRegister, false); err != nil {
+		t.Errorf("first register send: %v", err)
+	}
+
+	for i := range resendIntervals {
+		age(t, server, PurposeLogin, resendIntervals[i])
+		resend, err := ReserveSend(ctx, testMobile, PurposeLogin, false)
+		if err != nil {
+			t.Fatalf("resend %d: %v", i+1, err)
+		}
+		if resend.Remaining != len(resendIntervals)-i-1 {
+			t.Errorf("resend %d leaves %d resends, want %d", i+1, resend.Remaining, len(resendIntervals)-i-1)
+		}
+	}
+
+	age(t, server, PurposeLogin, time.Hour-time.Second)
+	if _, err := ReserveSend(ctx, testMobile, PurposeLogin, false); !errors.Is(err, ErrResendsExhausted) {
+		t.Errorf("SMS after the last resend = %v, want exhausted", err)
+	}
+	if _, err := ReserveSend(ctx, testMobile, PurposeLogin, true); err != nil {
+		t.Errorf("voice after the last resend: %v", err)
+	}
+}
+
+func TestReserveSendRefusesVoiceFirst(t *testing.T) {
+	useMiniredis(t)
+
+	if _, err := ReserveSend(context.Background(), testMobile, PurposeLogin, true); !errors.Is(err, ErrVoiceNotYet) {
+		t.Errorf("voice first = %v, want ErrVoiceNotYet", err)
+	}
+}
+
+func TestReleaseRestoresTheSequence(t *testing.T) {
+	server := useMiniredis(t)
+	ctx := context.Background()
+	key := resendPrefix + PurposeLogin + ":" + testMobile
+
+	// Releasing the first send leaves no wait behind
+	resend, err := ReserveSend(ctx, testMobile, PurposeLogin, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resend.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if server.Exists(key) {
+		t.Error("released first send left resend state")
+	}
+
+	// Releasing a resend gives it back and restores the previous send time
+	if _, err := ReserveSend(ctx, testMobile, PurposeLogin, false); err != nil {
+		t.Fatal(err)
+	}
+	age(t, server, PurposeLogin, resendIntervals[0])
+	before := server.HGet(key, "last_sent_at")
+	resend, err = ReserveSend(ctx, testMobile, PurposeLogin, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := resend.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if resends, last := server.HGet(key, "resends"), server.HGet(key, "last_sent_at"); resends != "0" || last != before {
+		t.Errorf("after release resends = %s, last_sent_at = %s, want 0 and %s", resends, last, before)
+	}
+	if _, err := ReserveSend(ctx, testMobile, PurposeLogin, false); err != nil {
+		t.Errorf("resend after release: %v", err)
+	}
+}
@@ -0,0 +1,278 @@
+package otp
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"otp-auth-system/cache"
+	"otp-auth-system/utils"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// TTL is how long an issued OTP stays valid
+const TTL = 5 * time.Minute
+
+// OTP purposes; a code is only accepted by the flow it was issued for
+const (
+	PurposeLogin           = "login"
+	PurposeRegister        = "register"
+	PurposePhoneChange     = "phone_change"
+	PurposeSensitiveAction = "sensitive_action"
+	PurposeAuth            = "auth" // Unified start/complete flow, bound to its challenge ID
+)
+
+// Scope ties an OTP to the flow it was issued for
+type Scope struct {
+	Purpose string
+	Context string // Optional binding such as a device fingerprint or a hash of the action payload
+}
+
+// keyPrefix namespaces OTP records in Redis
+const keyPrefix = "otp:"
+
+// legacyCode matches the plaintext OTPs stored before hashing, which were always 6 digits
+var legacyCode = regexp.MustCompile(`^[0-9]{6}$`)
+
+// ErrInvalid is returned when no matching, unexpired OTP exists
+var ErrInvalid = errors.New("invalid or expired OTP")
+
+// Record is the metadata stored alongside an OTP hash
+type Record struct {
+	Hash     string
+	IssuedAt time.Time
+	Purpose  string
+	Attempts int
+}
+
+// hmacKey keys the OTP hashes so a Redis dump cannot be brute-forced offline
+var hmacKey []byte
+
+// Init loads the OTP hashing key from OTP_HMAC_KEY, falling back to JWT_SECRET, the attempt policy
+// and the resend intervals
+func Init() error {
+	key := os.Getenv("OTP_HMAC_KEY")
+	if key == "" {
+		key = os.Getenv("JWT_SECRET")
+		if key == "" {
+			return fmt.Errorf("OTP_HMAC_KEY is not set")
+		}
+		log.Println("OTP_HMAC_KEY is not set, falling back to JWT_SECRET for OTP hashing")
+	}
+	hmacKey = []byte(key)
+
+	initLockout()
+	return initResend()
+}
+
+// Key returns the Redis key of the OTP record for a mobile number and purpose
+func Key(mobile, purpose string) string {
+	return keyPrefix + purpose + ":" + mobile
+}
+
+// hash computes the keyed hash of a code, bound to the number and scope it was issued for
+func hash(mobile string, scope Scope, code string) string {
+	input := mobile + ":" + scope.Purpose + ":" + utils.NormalizeOTP(code)
+	if scope.Context != "" {
+		input += ":" + scope.Context
+	}
+
+	mac := hmac.New(sha256.New, hmacKey)
+	mac.Write([]byte(input))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Issue stores a new OTP for the mobile number and scope, replacing any previous one for the
+// same purpose. It returns the Redis key and the stored hash, which identifies this particular OTP.
+func Issue(ctx context.Context, mobile, code string, scope Scope) (string, string, error) {
+	key := Key(mobile, scope.Purpose)
+	digest := hash(mobile, scope, code)
+	_, err := cache.RDB.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		pipe.HSet(ctx, key, map[string]interface{}{
+			"hash":      digest,
+			"issued_at": time.Now().Unix(),
+			"purpose":   scope.Purpose,
+			"attempts":  0,
+		})
+		pipe.Expire(ctx, key, TTL)
+		return nil
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return key, digest, nil
+}
+
+// Verify checks a code against the OTP issued for the scope and consumes it on success.
+// Codes issued for another purpose or context are rejected. The comparison runs in
+// constant time, and a code can only be consumed once. Each OTP allows OTP_MAX_ATTEMPTS
+// guesses; failures are reported as a *VerifyError.
+func Verify(ctx context.Context, mobile, code string, scope Scope) error {
+	lockedFor, err := LockedFor(ctx, mobile)
+	if err != nil {
+		return err
+	}
+	if lockedFor > 0 {
+		return &VerifyError{Err: ErrLocked, RetryAfter: lockedFor}
+	}
+
+	record, err := load(ctx, mobile, scope.Purpose)
+	if err != nil {
+		return err
+	}
+
+	key := Key(mobile, scope.Purpose)
+	attempts, err := reserveAttempt(ctx, key)
+	if err != nil {
+		return err
+	}
+	if attempts > maxAttempts {
+		return ErrInvalid
+	}
+
+	expected, _ := hex.DecodeString(record.Hash)
+	provided, _ := hex.DecodeString(hash(mobile, scope, code))
+	if !hmac.Equal(expected, provided) {
+		remaining := maxAttempts - attempts
+		if remaining > 0 {
+			return &VerifyError{Err: ErrInvalid, AttemptsRemaining: remaining}
+		}
+
+		// Out of attempts: the OTP is invalidated and repeat offenders are locked out
+		lockout, err := invalidate(ctx, mobile, key)
+		if err != nil {
+			return err
+		}
+		if lockout > 0 {
+			return &VerifyError{Err: ErrLocked, RetryAfter: lockout}
+		}
+		return &VerifyError{Err: ErrInvalid}
+	}
+
+	// Deleting decides the winner if the same code is submitted concurrently
+	deleted, err := cache.RDB.Del(ctx, key).Result()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrInvalid
+	}
+	return nil
+}
+
+// Delete removes any pending OTP for the mobile number and purpose
+func Delete(ctx context.Context, mobile, purpose string) error {
+	return cache.RDB.Del(ctx, Key(mobile, purpose)).Err()
+}
+
+// discardScript deletes an OTP record only while it still holds the given hash
+var discardScript = redis.NewScript(`
+if redis.call("HGET", KEYS[1], "hash") == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Discard removes the OTP stored under key if it is still the one issued with digest, so a newer
+// OTP for the same purpose is left alone
+func Discard(ctx context.Context, key, digest string) error {
+	return discardScript.Run(ctx, cache.RDB, []string{key}, digest).Err()
+}
+
+// load reads the OTP record for a mobile number and purpose, migrating a legacy login OTP if needed
+func load(ctx context.Context, mobile, purpose string) (*Record, error) {
+	key := Key(mobile, purpose)
+	fields, err := cache.RDB.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) == 0 {
+		if purpose != PurposeLogin {
+			return nil, ErrInvalid
+		}
+		return migrateLegacy(ctx, mobile)
+	}
+
+	issuedAt, _ := strconv.ParseInt(fields["issued_at"], 10, 64)
+	attempts, _ := strconv.Atoi(fields["attempts"])
+	return &Record{
+		Hash:     fields["hash"],
+		IssuedAt: time.Unix(issuedAt, 0),
+		Purpose:  fields["purpose"],
+		Attempts: attempts,
+	}, nil
+}
+
+// migrateLegacy moves a login OTP written before OTPs were scoped by purpose to its scoped key.
+// Unscoped hashed records ("otp:<mobile>") are renamed as their hash format is unchanged, and
+// plaintext OTPs stored under the bare mobile number are hashed, keeping their remaining lifetime.
+// The mobile number is used as a raw key here, so only well-formed numbers holding a 6-digit
+// code are migrated; anything else is never read, renamed or deleted.
+func migrateLegacy(ctx context.Context, mobile string) (*Record, error) {
+	if !utils.ValidMobile(mobile) {
+		return nil, ErrInvalid
+	}
+	key := Key(mobile, PurposeLogin)
+
+	unscoped, err := cache.RDB.Exists(ctx, keyPrefix+mobile).Result()
+	if err != nil {
+		return nil, err
+	}
+	if unscoped == 1 {
+		if err := cache.RDB.RenameNX(ctx, keyPrefix+mobile, key).Err(); err != nil {
+			return nil, err
+		}
+		return load(ctx, mobile, PurposeLogin)
+	}
+
+	code, err := cache.RDB.Get(ctx, mobile).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrInvalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !legacyCode.MatchString(code) {
+		return nil, ErrInvalid
+	}
+
+	ttl, err := cache.RDB.PTTL(ctx, mobile).Result()
+	if err != nil {
+		return nil, err
+	}
+	if ttl <= 0 || ttl > TTL {
+		ttl = TTL
+	}
+
+	record := &Record{
+		Hash:     hash(mobile, Scope{Purpose: PurposeLogin}, code),
+		IssuedAt: time.Now().Add(ttl - TTL),
+		Purpose:  PurposeLogin,
+	}
+
+	_, err = cache.RDB.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, map[string]interface{}{
+			"hash":      record.Hash,
+			"issued_at": record.IssuedAt.Unix(),
+			"purpose":   record.Purpose,
+			"attempts":  0,
+		})
+		pipe.PExpire(ctx, key, ttl)
+		pipe.Del(ctx, mobile)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
@@ -0,0 +1,233 @@
+package ratelimit
+
+import (
+	"context"
+	"strings"
+	"time"
+	"unicode"
+
+	"otp-auth-system/cache"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// keyPrefix namespaces rate limit state in Redis
+const keyPrefix = "ratelimit:"
+
+// Algorithm selects how a rule counts requests
+type Algorithm string
+
+// Rate limit algorithms
+const (
+	SlidingWindow Algorithm = "sliding" // At most Limit requests in any Window
+	TokenBucket   Algorithm = "bucket"  // Bursts of up to Limit requests, refilled at Limit per Window
+)
+
+// Dimension is what a rule counts requests by
+type Dimension string
+
+// Rate limit dimensions
+const (
+	Mobile Dimension = "mobile"
+	IP     Dimension = "ip"
+	Device Dimension = "device"
+	Prefix Dimension = "prefix" // Leading digits of the mobile number, which catch pumping across a number range
+	Global Dimension = "global" // Every request to the endpoint
+)
+
+// Dimensions lists every dimension in the order rules are checked
+var Dimensions = []Dimension{Mobile, IP, Device, Prefix, Global}
+
+// Rule limits requests in one dimension
+type Rule struct {
+	Algorithm Algorithm
+	Limit     int
+	Window    time.Duration
+}
+
+// Subject identifies who is making a request. Empty fields skip their dimension.
+type Subject struct {
+	Mobile string
+	IP     string
+	Device string
+}
+
+// Result is the outcome of a rate limit check. Limit, Remaining and Reset describe the most
+// restrictive rule, or the rule that denied the request.
+type Result struct {
+	Allowed    bool
+	Dimension  Dimension
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // Until the limit is fully restored
+	RetryAfter time.Duration // Until the request would be allowed; zero when allowed
+}
+
+// PrefixLength is how many leading digits of a mobile number form its prefix, configured by RATE_LIMIT_PREFIX_LENGTH
+var PrefixLength = 5
+
+// allowScript checks every key's rule and only consumes from them when all allow the request,
+// so a request denied by one dimension does not use up the others.
+// ARGV holds the time in milliseconds and a unique request ID, then the algorithm, limit and
+// window in milliseconds of each key. It returns whether the request was allowed, followed by
+// the remaining requests, reset and retry-after in milliseconds of each key.
+var allowScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local request = ARGV[2]
+local allowed = 1
+local state = {}
+
+for i, key in ipairs(KEYS) do
+	local base = 2 + (i - 1) * 3
+	local algorithm = ARGV[base + 1]
+	local limit = tonumber(ARGV[base + 2])
+	local window = tonumber(ARGV[base + 3])
+	local s = {algorithm = algorithm, limit = limit, window = window, retry = 0}
+
+	if algorithm == "sliding" then
+		redis.call("ZREMRANGEBYSCORE", key, "-inf", now - window)
+		s.count = redis.call("ZCARD", key)
+		if s.count >= limit then
+			local blocking = redis.call("ZRANGE", key, s.count - limit, s.count - limit, "WITHSCORES")
+			s.retry = tonumber(blocking[2]) + window - now
+		end
+	else
+		local bucket = redis.call("HMGET", key, "tokens", "ts")
+		local rate = limit / window
+		local tokens = tonumber(bucket[1]) or limit
+		local ts = tonumber(bucket[2]) or now
+		s.rate = rate
+		s.tokens = math.min(limit, tokens + math.max(0, now - ts) * rate)
+		if s.tokens < 1 then
+			s.retry = math.ceil((1 - s.tokens) / rate)
+		end
+	end
+
+	if s.retry > 0 then
+		allowed = 0
+	end
+	state[i] = s
+end
+
+local result = {allowed}
+for i, key in ipairs(KEYS) do
+	local s = state[i]
+	local remaining, reset
+
+	if s.algorithm == "sliding" then
+		if allowed == 1 then
+			redis.call("ZADD", key, now, request)
+			redis.call("PEXPIRE", key, s.window)
+			s.count = s.count + 1
+		end
+		remaining = s.limit - s.count
+		local oldest = redis.call("ZRANGE", key, 0, 0, "WITHSCORES")
+		reset = 0
+		if #oldest > 0 then
+			reset = tonumber(oldest[2]) + s.window - now
+		end
+	else
+		if allowed == 1 then
+			s.tokens = s.tokens - 1
+			redis.call("HSET", key, "tokens", tostring(s.tokens), "ts", now)
+			redis.call("PEXPIRE", key, s.window)
+		end
+		remaining = math.floor(s.tokens)
+		reset = math.ceil((s.limit - s.tokens) / s.rate)
+	end
+
+	table.insert(result, math.max(0, remaining))
+	table.insert(result, math.max(0, reset))
+	table.insert(result, s.retry)
+end
+return result
+`)
+
+// Allow checks a request against the policy's rules and counts it if every rule allows it
+func (p *Policy) Allow(ctx context.Context, subject Subject) (*Result, error) {
+	var keys []string
+	var rules []Rule
+	var dimensions []Dimension
+	args := []interface{}{time.Now().UnixMilli(), uuid.NewString()}
+
+	for _, dimension := range Dimensions {
+		rule, ok := p.Rules[dimension]
+		value := subject.value(dimension)
+		if !ok || rule.Limit <= 0 || value == "" {
+			continue
+		}
+		keys = append(keys, keyPrefix+p.Name+":"+string(dimension)+":"+value)
+		rules = append(rules, rule)
+		dimensions = append(dimensions, dimension)
+		args = append(args, string(rule.Algorithm), rule.Limit, rule.Window.Milliseconds())
+	}
+
+	if len(keys) == 0 {
+		return &Result{Allowed: true}, nil
+	}
+
+	values, err := allowScript.Run(ctx, cache.RDB, keys, args...).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{Allowed: values[0] == 1}
+	for i, rule := range rules {
+		remaining := int(values[1+i*3])
+		reset := time.Duration(values[2+i*3]) * time.Millisecond
+		retryAfter := time.Duration(values[3+i*3]) * time.Millisecond
+
+		// Report the rule that blocks longest, or else the one closest to blocking
+		var moreRestrictive bool
+		switch {
+		case i == 0:
+			moreRestrictive = true
+		case retryAfter != result.RetryAfter:
+			moreRestrictive = retryAfter > result.RetryAfter
+		case remaining != result.Remaining:
+			moreRestrictive = remaining < result.Remaining
+		default:
+			moreRestrictive = reset > result.Reset
+		}
+		if moreRestrictive {
+			result.Dimension = dimensions[i]
+			result.Limit = rule.Limit
+			result.Remaining = remaining
+			result.Reset = reset
+			result.RetryAfter = retryAfter
+		}
+	}
+	return result, nil
+}
+
+// value returns what the subject is counted by in a dimension
+func (s Subject) value(dimension Dimension) string {
+	switch dimension {
+	case Mobile:
+		return s.Mobile
+	case IP:
+		return s.IP
+	case Device:
+		return s.Device
+	case Prefix:
+		return prefix(s.Mobile)
+	case Global:
+		return "all"
+	}
+	return ""
+}
+
+// prefix returns the leading digits of a mobile number
+func prefix(mobile string) string {
+	digits := strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, mobile)
+	if len(digits) < PrefixLength {
+		return ""
+	}
+	return digits[:PrefixLength]
+}
@@ -0,0 +1,92 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"otp-auth-system/cache"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// useMiniredis points the cache at a fresh in-memory Redis
+func useMiniredis(t *testing.T) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	cache.RDB = redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { cache.RDB.Close() })
+}
+
+// allow runs a check and fails the test on Redis errors
+func allow(t *testing.T, policy *Policy, subject Subject) *Result {
+	t.Helper()
+	result, err := policy.Allow(context.Background(), subject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestSlidingWindowDeniesWithoutConsumingOtherRules(t *testing.T) {
+	useMiniredis(t)
+	policy := &Policy{Name: "test", Rules: map[Dimension]Rule{
+		Mobile: {SlidingWindow, 2, time.Hour},
+		IP:     {SlidingWindow, 3, time.Hour},
+	}}
+	subject := Subject{Mobile: "+919876543210", IP: "203.0.113.7"}
+
+	for i := 0; i < 2; i++ {
+		if result := allow(t, policy, subject); !result.Allowed {
+			t.Fatalf("request %d denied by %s", i+1, result.Dimension)
+		}
+	}
+	result := allow(t, policy, subject)
+	if result.Allowed || result.Dimension != Mobile || result.Limit != 2 || result.RetryAfter <= 0 {
+		t.Fatalf("third request = %+v, want denied by the mobile rule", result)
+	}
+
+	// The denied request did not count against the IP, which has one request left
+	subject.Mobile = "+919876543211"
+	if result := allow(t, policy, subject); !result.Allowed || result.Dimension != IP || result.Remaining != 0 {
+		t.Errorf("request from another number = %+v, want allowed with no IP requests left", result)
+	}
+	if result := allow(t, policy, subject); result.Allowed || result.Dimension != IP {
+		t.Errorf("next request = %+v, want denied by the IP rule", result)
+	}
+}
+
+func TestTokenBucketRefills(t *testing.T) {
+	useMiniredis(t)
+	policy := &Policy{Name: "test", Rules: map[Dimension]Rule{
+		Global: {TokenBucket, 2, time.Minute},
+	}}
+
+	for i := 0; i < 2; i++ {
+		if result := allow(t, policy, Subject{}); !result.Allowed {
+			t.Fatalf("burst request %d denied", i+1)
+		}
+	}
+	result := allow(t, policy, Subject{})
+	if result.Allowed {
+		t.Fatal("request beyond the burst allowed")
+	}
+	// One token comes back every 30 seconds
+	if result.RetryAfter <= 0 || result.RetryAfter > 30*time.Second {
+		t.Errorf("retry after %s, want at most 30s", result.RetryAfter)
+	}
+}
+
+func TestAllowSkipsEmptyDimensions(t *testing.T) {
+	useMiniredis(t)
+	policy := &Policy{Name: "test", Rules: map[Dimension]Rule{
+		Device: {SlidingWindow, 1, time.Hour},
+	}}
+
+	for i := 0; i < 3; i++ {
+		if result := allow(t, policy, Subject{Mobile: "+919876543210"}); !result.Allowed || result.Limit != 0 {
+			t.Fatalf("request without a device = %+v, want allowed with no limit applied", result)
+		}
+	}
+}
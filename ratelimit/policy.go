@@ -0,0 +1,119 @@
+package ratelimit
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy is the set of rules applied to one endpoint
+type Policy struct {
+	Name  string
+	Rules map[Dimension]Rule
+}
+
+// Policies for the OTP endpoints. SMS-sending endpoints are limited by number prefix and
+// globally as well, since SMS pumping spreads requests across many numbers.
+var (
+	Login = &Policy{Name: "login", Rules: map[Dimension]Rule{
+		Mobile: {SlidingWindow, 5, time.Hour},
+		IP:     {SlidingWindow, 20, time.Hour},
+		Device: {SlidingWindow, 10, time.Hour},
+		Prefix: {SlidingWindow, 100, time.Hour},
+		Global: {TokenBucket, 300, time.Minute},
+	}}
+	Register = &Policy{Name: "register", Rules: map[Dimension]Rule{
+		Mobile: {SlidingWindow, 3, time.Hour},
+		IP:     {SlidingWindow, 10, time.Hour},
+		Device: {SlidingWindow, 5, time.Hour},
+		Prefix: {SlidingWindow, 50, time.Hour},
+		Global: {TokenBucket, 100, time.Minute},
+	}}
+	Resend = &Policy{Name: "resend", Rules: map[Dimension]Rule{
+		Mobile: {SlidingWindow, 5, time.Hour},
+		IP:     {SlidingWindow, 20, time.Hour},
+		Device: {SlidingWindow, 10, time.Hour},
+		Prefix: {SlidingWindow, 100, time.Hour},
+		Global: {TokenBucket, 300, time.Minute},
+	}}
+	// Send caps the OTPs sent to a number across /login, /register, /resend-otp and /auth/start
+	Send = &Policy{Name: "send", Rules: map[Dimension]Rule{
+		Mobile: {SlidingWindow, 6, time.Hour},
+	}}
+	Verify = &Policy{Name: "verify", Rules: map[Dimension]Rule{
+		Mobile: {SlidingWindow, 10, 15 * time.Minute},
+		IP:     {SlidingWindow, 50, 15 * time.Minute},
+		Device: {SlidingWindow, 20, 15 * time.Minute},
+		Global: {TokenBucket, 1000, time.Minute},
+	}}
+	// Trusted limits trusted-device logins, so device secrets cannot be guessed
+	Trusted = &Policy{Name: "trusted", Rules: map[Dimension]Rule{
+		Mobile: {SlidingWindow, 10, 15 * time.Minute},
+		IP:     {SlidingWindow, 50, 15 * time.Minute},
+		Device: {SlidingWindow, 10, 15 * time.Minute},
+		Global: {TokenBucket, 1000, time.Minute},
+	}}
+
+	policies = []*Policy{Login, Register, Resend, Send, Verify, Trusted}
+)
+
+// Init loads rule overrides from the environment. RATE_LIMIT_<POLICY>_<DIMENSION> sets a rule as
+// "<limit>/<window>", optionally followed by ":bucket" for a token bucket or ":sliding" (the
+// default), e.g. RATE_LIMIT_LOGIN_IP=50/1h or RATE_LIMIT_LOGIN_GLOBAL=600/1m:bucket. "off"
+// disables the rule.
+func Init() error {
+	if value := os.Getenv("RATE_LIMIT_PREFIX_LENGTH"); value != "" {
+		length, err := strconv.Atoi(value)
+		if err != nil || length < 1 {
+			return fmt.Errorf("invalid RATE_LIMIT_PREFIX_LENGTH %q", value)
+		}
+		PrefixLength = length
+	}
+
+	for _, policy := range policies {
+		for _, dimension := range Dimensions {
+			name := strings.ToUpper("RATE_LIMIT_" + policy.Name + "_" + string(dimension))
+			value := os.Getenv(name)
+			if value == "" {
+				continue
+			}
+			rule, err := parseRule(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", name, err)
+			}
+			policy.Rules[dimension] = rule
+		}
+	}
+	return nil
+}
+
+// parseRule parses "<limit>/<window>[:<algorithm>]" or "off"
+func parseRule(value string) (Rule, error) {
+	if value == "off" {
+		return Rule{}, nil
+	}
+
+	rule := Rule{Algorithm: SlidingWindow}
+	if spec, algorithm, found := strings.Cut(value, ":"); found {
+		value = spec
+		rule.Algorithm = Algorithm(algorithm)
+		if rule.Algorithm != SlidingWindow && rule.Algorithm != TokenBucket {
+			return Rule{}, fmt.Errorf("unknown algorithm %q", algorithm)
+		}
+	}
+
+	limit, window, found := strings.Cut(value, "/")
+	if !found {
+		return Rule{}, fmt.Errorf("expected <limit>/<window>")
+	}
+	var err error
+	if rule.Limit, err = strconv.Atoi(limit); err != nil || rule.Limit < 1 {
+		return Rule{}, fmt.Errorf("invalid limit %q", limit)
+	}
+	if rule.Window, err = time.ParseDuration(window); err != nil || rule.Window < time.Millisecond {
+		return Rule{}, fmt.Errorf("invalid window %q", window)
+	}
+	return rule, nil
+}
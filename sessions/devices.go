@@ -0,0 +1,112 @@
+package sessions
+
+import (
+	"context"
+	"errors"
+
+	"otp-auth-system/cache"
+	"otp-auth-system/db"
+	"otp-auth-system/models"
+	"otp-auth-system/tokens"
+	"otp-auth-system/utils"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+)
+
+// RemoveDevice deletes one of the user's devices and ends every session on it, revoking its
+// refresh tokens and the access token stored for the device. It returns the number of devices
+// removed and sessions terminated.
+func RemoveDevice(ctx context.Context, mobile, deviceID string) (int, int, error) {
+	return removeDevices(ctx, mobile, func(tx *sqlx.Tx) ([]string, error) {
+		var deviceIDs []string
+		err := tx.SelectContext(ctx, &deviceIDs, "DELETE FROM user_devices WHERE mobile = $1 AND device_id = $2 RETURNING device_id",
+			mobile, deviceID)
+		return deviceIDs, err
+	})
+}
+
+// RemoveOtherDevices deletes every device of the user except the current one, like RemoveDevice
+func RemoveOtherDevices(ctx context.Context, mobile, currentDeviceID string) (int, int, error) {
+	return removeDevices(ctx, mobile, func(tx *sqlx.Tx) ([]string, error) {
+		var deviceIDs []string
+		err := tx.SelectContext(ctx, &deviceIDs, "DELETE FROM user_devices WHERE mobile = $1 AND device_id != $2 RETURNING device_id",
+			mobile, currentDeviceID)
+		return deviceIDs, err
+	})
+}
+
+// RemoveAllDevices deletes every device of the user, like RemoveDevice
+func RemoveAllDevices(ctx context.Context, mobile string) (int, int, error) {
+	return removeDevices(ctx, mobile, func(tx *sqlx.Tx) ([]string, error) {
+		var deviceIDs []string
+		err := tx.SelectContext(ctx, &deviceIDs, "DELETE FROM user_devices WHERE mobile = $1 RETURNING device_id", mobile)
+		return deviceIDs, err
+	})
+}
+
+// removeDevices runs remove, which deletes devices and returns their IDs, then ends the sessions on
+// those devices and revokes their tokens.
+//
+// Tokens are revoked in a single Redis transaction before the database changes are committed,
+// so a failure leaves the devices in place rather than removed with working tokens.
+func removeDevices(ctx context.Context, mobile string, remove func(tx *sqlx.Tx) ([]string, error)) (int, int, error) {
+	tx, err := db.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	deviceIDs, err := remove(tx)
+	if err != nil || len(deviceIDs) == 0 {
+		return 0, 0, err
+	}
+
+	var ended []models.Session
+	err = tx.SelectContext(ctx, &ended, `UPDATE sessions SET status = $1, revoked_at = NOW()
+		WHERE status = $2 AND mobile = $3 AND device_id = ANY($4) RETURNING *`,
+		models.SessionStatusRevoked, models.SessionStatusActive, mobile, pq.Array(deviceIDs))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// Read the access tokens stored for the devices so they can be revoked by ID
+	var accessTokens []*utils.Claims
+	for _, deviceID := range deviceIDs {
+		token, err := cache.RDB.Get(ctx, cache.DeviceTokenKey(mobile, deviceID)).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		if claims, err := utils.ValidateJWT(token); err == nil {
+			accessTokens = append(accessTokens, claims)
+		}
+	}
+
+	_, err = cache.RDB.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i := range ended {
+			if err := storeIn(ctx, pipe, &ended[i]); err != nil {
+				return err
+			}
+			tokens.RevokeFamilyIn(ctx, pipe, ended[i].ID.String())
+		}
+		for _, claims := range accessTokens {
+			cache.RevokeTokenIn(ctx, pipe, claims.ID, claims.ExpiresAt.Time)
+		}
+		for _, deviceID := range deviceIDs {
+			pipe.Del(ctx, cache.DeviceTokenKey(mobile, deviceID))
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	return len(deviceIDs), len(ended), nil
+}
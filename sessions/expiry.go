@@ -0,0 +1,103 @@
+package sessions
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"otp-auth-system/cache"
+	"otp-auth-system/db"
+	"otp-auth-system/models"
+)
+
+// Session lifetimes, configured by SESSION_ABSOLUTE_TTL, SESSION_IDLE_TTL and SESSION_TOUCH_INTERVAL
+var (
+	AbsoluteTTL   = 30 * 24 * time.Hour // A session ends this long after login, however active it is
+	IdleTTL       = 7 * 24 * time.Hour  // A session ends after going unused for this long
+	TouchInterval = time.Minute         // Last-seen is written at most once per interval
+)
+
+// sweepInterval is how often idle and expired sessions are ended in the background
+const sweepInterval = 5 * time.Minute
+
+// touchPrefix namespaces the markers that throttle last-seen writes
+const touchPrefix = "session_touch:"
+
+// Init loads the session lifetimes
+func Init() {
+	AbsoluteTTL = envDuration("SESSION_ABSOLUTE_TTL", AbsoluteTTL)
+	IdleTTL = envDuration("SESSION_IDLE_TTL", IdleTTL)
+	TouchInterval = envDuration("SESSION_TOUCH_INTERVAL", TouchInterval)
+}
+
+// Expired reports whether a session has passed its absolute lifetime or been idle too long
+func Expired(session *models.Session) bool {
+	now := time.Now()
+	return !now.Before(session.ExpiresAt) || now.Sub(session.LastSeenAt) >= IdleTTL
+}
+
+// Expire ends a session that has expired
+func Expire(ctx context.Context, id string) error {
+	return endSession(ctx, id, models.SessionStatusExpired)
+}
+
+// Touch records activity on a session and its device, writing at most once per TouchInterval
+func Touch(ctx context.Context, session *models.Session, ip string) error {
+	if time.Since(session.LastSeenAt) < TouchInterval {
+		return nil
+	}
+
+	// Only one request per interval writes, even across instances
+	first, err := cache.RDB.SetNX(ctx, touchPrefix+session.ID.String(), "1", TouchInterval).Result()
+	if err != nil || !first {
+		return err
+	}
+
+	var touched []models.Session
+	err = db.DB.SelectContext(ctx, &touched, "UPDATE sessions SET last_seen_at = NOW() WHERE id = $1 AND status = $2 RETURNING *",
+		session.ID, models.SessionStatusActive)
+	if err != nil {
+		return err
+	}
+	if err := storeAll(ctx, touched); err != nil {
+		return err
+	}
+
+	_, err = db.DB.ExecContext(ctx, "UPDATE user_devices SET last_seen_at = NOW(), last_ip = $1 WHERE mobile = $2 AND device_id = $3",
+		ip, session.Mobile, session.DeviceID)
+	return err
+}
+
+// StartSweeper periodically ends sessions that have expired or gone idle
+func StartSweeper() {
+	go func() {
+		for {
+			time.Sleep(sweepInterval)
+			if err := expireIdle(context.Background()); err != nil {
+				fmt.Println("Failed to end idle sessions:", err)
+			}
+		}
+	}()
+}
+
+// expireIdle ends every active session past its absolute lifetime or idle for longer than IdleTTL
+func expireIdle(ctx context.Context) error {
+	var ended []models.Session
+	err := db.DB.SelectContext(ctx, &ended, `UPDATE sessions SET status = $1, revoked_at = NOW()
+		WHERE status = $2 AND (expires_at <= NOW() OR last_seen_at <= $3) RETURNING *`,
+		models.SessionStatusExpired, models.SessionStatusActive, time.Now().Add(-IdleTTL))
+	if err != nil {
+		return err
+	}
+	return settle(ctx, ended)
+}
+
+// envDuration reads a positive duration from the environment
+func envDuration(name string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
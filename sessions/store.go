@@ -0,0 +1,169 @@
+package sessions
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"otp-auth-system/cache"
+	"otp-auth-system/db"
+	"otp-auth-system/models"
+	"otp-auth-system/tokens"
+	"otp-auth-system/utils"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// cachePrefix namespaces cached sessions in Redis
+const cachePrefix = "session:"
+
+// cacheTTL bounds how long a cached session is trusted before it is reloaded from Postgres
+const cacheTTL = 10 * time.Minute
+
+// ErrNotFound is returned when a session does not exist
+var ErrNotFound = errors.New("session not found")
+
+// Create persists a new active session. The session ID must already be set, since it is
+// shared with the refresh token family of the login.
+func Create(ctx context.Context, session *models.Session) error {
+	session.Status = models.SessionStatusActive
+	session.ExpiresAt = time.Now().Add(AbsoluteTTL)
+	err := db.DB.GetContext(ctx, session, `INSERT INTO sessions (id, user_id, mobile, device_id, ip, user_agent, status, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING *`,
+		session.ID, session.UserID, session.Mobile, session.DeviceID, session.IP, session.UserAgent, session.Status, session.ExpiresAt)
+	if err != nil {
+		return err
+	}
+	return store(ctx, session)
+}
+
+// Get returns a session, preferring the Redis cache over Postgres
+func Get(ctx context.Context, id string) (*models.Session, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, ErrNotFound
+	}
+
+	data, err := cache.RDB.Get(ctx, cachePrefix+id).Bytes()
+	if err == nil {
+		var session models.Session
+		if json.Unmarshal(data, &session) == nil {
+			return &session, nil
+		}
+	} else if !errors.Is(err, redis.Nil) {
+		return nil, err
+	}
+
+	var session models.Session
+	err = db.DB.GetContext(ctx, &session, "SELECT * FROM sessions WHERE id = $1", id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &session, store(ctx, &session)
+}
+
+// List returns the live sessions of a user, most recently seen first
+func List(ctx context.Context, userID string) ([]models.Session, error) {
+	sessions := []models.Session{}
+	err := db.DB.SelectContext(ctx, &sessions, `SELECT * FROM sessions
+		WHERE user_id = $1 AND status = $2 AND expires_at > NOW() AND last_seen_at > $3
+		ORDER BY last_seen_at DESC`,
+		userID, models.SessionStatusActive, time.Now().Add(-IdleTTL))
+	return sessions, err
+}
+
+// Revoke ends a session and revokes the refresh tokens issued to it
+func Revoke(ctx context.Context, id string) error {
+	return endSession(ctx, id, models.SessionStatusRevoked)
+}
+
+// RevokeCompromised ends a session whose refresh token was reused and revokes the access token
+// last issued to its device, so neither the attacker nor the victim keeps a working token
+func RevokeCompromised(ctx context.Context, family *tokens.Family) error {
+	if err := Revoke(ctx, family.ID); err != nil {
+		return err
+	}
+
+	tokenKey := cache.DeviceTokenKey(family.Mobile, family.Device)
+	token, err := cache.RDB.Get(ctx, tokenKey).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	claims, err := utils.ValidateJWT(token)
+	if err != nil || claims.SessionID != family.ID {
+		return nil // Expired, or already replaced by a token from another login
+	}
+	if err := cache.RevokeToken(claims.ID, claims.ExpiresAt.Time); err != nil {
+		return err
+	}
+	return cache.RDB.Del(ctx, tokenKey).Err()
+}
+
+// RevokeAll ends every active session of a user and returns how many it ended
+func RevokeAll(ctx context.Context, userID string) (int, error) {
+	var ended []models.Session
+	err := db.DB.SelectContext(ctx, &ended, `UPDATE sessions SET status = $1, revoked_at = NOW()
+		WHERE user_id = $2 AND status = $3 RETURNING *`,
+		models.SessionStatusRevoked, userID, models.SessionStatusActive)
+	if err != nil {
+		return 0, err
+	}
+	return len(ended), settle(ctx, ended)
+}
+
+// endSession moves an active session to status and revokes its refresh tokens
+func endSession(ctx context.Context, id, status string) error {
+	var ended []models.Session
+	err := db.DB.SelectContext(ctx, &ended, `UPDATE sessions SET status = $1, revoked_at = NOW()
+		WHERE id = $2 AND status = $3 RETURNING *`,
+		status, id, models.SessionStatusActive)
+	if err != nil {
+		return err
+	}
+	return settle(ctx, ended)
+}
+
+// settle caches sessions that have just ended and revokes their refresh tokens
+func settle(ctx context.Context, ended []models.Session) error {
+	if err := storeAll(ctx, ended); err != nil {
+		return err
+	}
+	for _, session := range ended {
+		if err := tokens.RevokeFamily(ctx, session.ID.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// store caches a session. Revoked sessions are cached too, so a stale active copy is never served.
+func store(ctx context.Context, session *models.Session) error {
+	return storeIn(ctx, cache.RDB, session)
+}
+
+// storeIn caches a session using the given client or transaction
+func storeIn(ctx context.Context, rdb redis.Cmdable, session *models.Session) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return rdb.Set(ctx, cachePrefix+session.ID.String(), data, cacheTTL).Err()
+}
+
+// storeAll caches several sessions
+func storeAll(ctx context.Context, sessions []models.Session) error {
+	for i := range sessions {
+		if err := store(ctx, &sessions[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
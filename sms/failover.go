@@ -0,0 +1,209 @@
+package sms
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states reported in provider health
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+// ErrNoProviderAvailable is returned when every provider's circuit is open
+var ErrNoProviderAvailable = errors.New("no SMS provider available")
+
+// ProviderHealth is a snapshot of a provider's delivery record
+type ProviderHealth struct {
+	Name                string     `json:"name"`
+	State               string     `json:"state"`
+	Successes           int64      `json:"successes"`
+	Failures            int64      `json:"failures"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	AvgLatencyMs        float64    `json:"avg_latency_ms"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastFailureAt       *time.Time `json:"last_failure_at,omitempty"`
+	OpenUntil           *time.Time `json:"open_until,omitempty"`
+}
+
+// HealthReporter is implemented by providers that track per-provider health
+type HealthReporter interface {
+	Health() []ProviderHealth
+}
+
+// BreakerConfig controls when a provider is taken out of rotation
+type BreakerConfig struct {
+	FailureThreshold int           // Consecutive failures that open the circuit
+	Cooldown         time.Duration // Time an open circuit waits before allowing a trial send
+}
+
+// providerState tracks the health of one provider in a failover chain
+type providerState struct {
+	provider Provider
+	health   ProviderHealth
+	trial    bool // A half-open trial send is in flight
+}
+
+// Failover sends through an ordered list of providers, skipping those whose circuit is open
+type Failover struct {
+	mu        sync.Mutex
+	providers []*providerState
+	config    BreakerConfig
+}
+
+// NewFailover creates a failover chain; providers are tried in the given order
+func NewFailover(config BreakerConfig, providers ...Provider) (*Failover, error) {
+	if len(providers) == 0 {
+		return nil, fmt.Errorf("at least one SMS provider is required")
+	}
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = 5
+	}
+	if config.Cooldown <= 0 {
+		config.Cooldown = 30 * time.Second
+	}
+
+	states := make([]*providerState, 0, len(providers))
+	for _, p := range providers {
+		states = append(states, &providerState{
+			provider: p,
+			health:   ProviderHealth{Name: p.Name(), State: CircuitClosed},
+		})
+	}
+	return &Failover{providers: states, config: config}, nil
+}
+
+// Name lists the providers in the chain
+func (f *Failover) Name() string {
+	names := make([]string, 0, len(f.providers))
+	for _, s := range f.providers {
+		names = append(names, s.provider.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+// SendOTP tries each available provider in order until one accepts the message
+func (f *Failover) SendOTP(ctx context.Context, mobile string, otp string) (*Message, error) {
+	var errs []error
+	attempted := false
+
+	for _, state := range f.providers {
+		if !f.acquire(state) {
+			continue
+		}
+		attempted = true
+
+		start := time.Now()
+		msg, err := state.provider.SendOTP(ctx, mobile, otp)
+		f.record(state, time.Since(start), err)
+		if err == nil {
+			return msg, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", state.provider.Name(), err))
+
+		// Stop failing over once the caller has given up
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if !attempted {
+		return nil, ErrNoProviderAvailable
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Lookup returns the provider in the chain with the given name
+func (f *Failover) Lookup(name string) (Provider, bool) {
+	for _, state := range f.providers {
+		if state.provider.Name() == name {
+			return state.provider, true
+		}
+	}
+	return nil, false
+}
+
+// Health returns a snapshot of every provider's health
+func (f *Failover) Health() []ProviderHealth {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	snapshot := make([]ProviderHealth, 0, len(f.providers))
+	for _, state := range f.providers {
+		h := state.health
+		if h.State == CircuitOpen && h.OpenUntil != nil && !now.Before(*h.OpenUntil) {
+			h.State = CircuitHalfOpen
+		}
+		snapshot = append(snapshot, h)
+	}
+	return snapshot
+}
+
+// acquire reports whether a send may be attempted through the provider
+func (f *Failover) acquire(state *providerState) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch state.health.State {
+	case CircuitClosed:
+		return true
+	case CircuitOpen:
+		if state.health.OpenUntil != nil && time.Now().Before(*state.health.OpenUntil) {
+			return false
+		}
+		// Cooldown elapsed, let a single trial send through
+		state.health.State = CircuitHalfOpen
+		state.trial = true
+		return true
+	default:
+		if state.trial {
+			return false
+		}
+		state.trial = true
+		return true
+	}
+}
+
+// record updates the provider's health with the outcome of a send
+func (f *Failover) record(state *providerState, latency time.Duration, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	h := &state.health
+	state.trial = false
+
+	// Exponentially weighted moving average keeps recent latency dominant
+	ms := float64(latency) / float64(time.Millisecond)
+	if h.Successes+h.Failures == 0 {
+		h.AvgLatencyMs = ms
+	} else {
+		h.AvgLatencyMs = 0.8*h.AvgLatencyMs + 0.2*ms
+	}
+
+	if err == nil {
+		h.Successes++
+		h.ConsecutiveFailures = 0
+		h.State = CircuitClosed
+		h.OpenUntil = nil
+		return
+	}
+
+	now := time.Now()
+	h.Failures++
+	h.ConsecutiveFailures++
+	h.LastError = err.Error()
+	h.LastFailureAt = &now
+
+	if h.State == CircuitHalfOpen || h.ConsecutiveFailures >= f.config.FailureThreshold {
+		openUntil := now.Add(f.config.Cooldown)
+		h.State = CircuitOpen
+		h.OpenUntil = &openUntil
+	}
+}
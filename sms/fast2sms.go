@@ -0,0 +1,110 @@
+package sms
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const fast2smsURL = "https://www.fast2sms.com/dev/bulkV2"
+
+// Fast2SMS sends OTPs through the Fast2SMS OTP route
+type Fast2SMS struct {
+	apiKey        string
+	webhookSecret string
+}
+
+// NewFast2SMS creates a Fast2SMS provider
+func NewFast2SMS(apiKey string) (*Fast2SMS, error) {
+	if apiKey == "" {
+		return nil, fmt.Errorf("Fast2SMS API key not found in environment variables")
+	}
+	return &Fast2SMS{apiKey: apiKey, webhookSecret: webhookSecret("fast2sms")}, nil
+}
+
+// Name returns the provider identifier
+func (p *Fast2SMS) Name() string {
+	return "fast2sms"
+}
+
+// SendOTP sends the OTP as a form POST so the API key travels in a header, not the URL
+func (p *Fast2SMS) SendOTP(ctx context.Context, mobile string, otp string) (*Message, error) {
+	form := url.Values{}
+	form.Set("route", "otp")
+	form.Set("variables_values", otp)
+	form.Set("flash", "0")
+	form.Set("numbers", mobile)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fast2smsURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("authorization", p.apiKey)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Fast2SMS API request failed with status code: %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Return    bool   `json:"return"`
+		RequestID string `json:"request_id"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("Fast2SMS returned an unreadable response: %v", err)
+	}
+	if !result.Return {
+		return nil, fmt.Errorf("Fast2SMS rejected the message: %s", string(body))
+	}
+
+	return &Message{Provider: p.Name(), ID: result.RequestID}, nil
+}
+
+// fast2smsReport is the payload Fast2SMS posts to a delivery report webhook
+type fast2smsReport struct {
+	RequestID string `json:"request_id"`
+	Number    string `json:"number"`
+	Status    string `json:"status"`
+	Operator  string `json:"operator"`
+	Reason    string `json:"reason"`
+}
+
+// ParseReceipts authenticates a delivery report via the shared webhook token and reads its final state
+func (p *Fast2SMS) ParseReceipts(r *http.Request, body []byte) ([]Receipt, error) {
+	if err := verifySharedToken(r, p.webhookSecret); err != nil {
+		return nil, err
+	}
+
+	var report fast2smsReport
+	if err := json.Unmarshal(body, &report); err != nil {
+		return nil, fmt.Errorf("unreadable Fast2SMS delivery report: %v", err)
+	}
+
+	var status string
+	switch strings.ToLower(report.Status) {
+	case "delivered":
+		status = ReceiptDelivered
+	case "failed", "undelivered", "rejected", "expired":
+		status = ReceiptFailed
+	default:
+		return nil, nil
+	}
+
+	return []Receipt{{
+		MessageID: report.RequestID,
+		Status:    status,
+		Carrier:   report.Operator,
+		ErrorCode: report.Reason,
+	}}, nil
+}
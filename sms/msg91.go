@@ -0,0 +1,132 @@
+package sms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+const msg91FlowURL = "https://control.msg91.com/api/v5/flow/"
+
+// MSG91 sends OTPs through an approved MSG91 flow template
+type MSG91 struct {
+	authKey       string
+	templateID    string
+	webhookSecret string
+}
+
+// NewMSG91 creates an MSG91 provider
+func NewMSG91(authKey, templateID string) (*MSG91, error) {
+	if authKey == "" || templateID == "" {
+		return nil, fmt.Errorf("MSG91 auth key and template ID must be set")
+	}
+	return &MSG91{authKey: authKey, templateID: templateID, webhookSecret: webhookSecret("msg91")}, nil
+}
+
+// Name returns the provider identifier
+func (p *MSG91) Name() string {
+	return "msg91"
+}
+
+// SendOTP triggers the configured flow with the OTP as its "otp" variable
+func (p *MSG91) SendOTP(ctx context.Context, mobile string, otp string) (*Message, error) {
+	payload, err := json.Marshal(map[string]interface{}{
+		"template_id": p.templateID,
+		"short_url":   "0",
+		"recipients": []map[string]string{
+			{"mobiles": InternationalNumber(mobile), "otp": otp},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, msg91FlowURL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("authkey", p.authKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("MSG91 API request failed with status code: %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("MSG91 returned an unreadable response: %v", err)
+	}
+	if result.Type != "success" {
+		return nil, fmt.Errorf("MSG91 rejected the message: %s", result.Message)
+	}
+
+	// MSG91 returns the request ID in the message field of a successful flow call
+	return &Message{Provider: p.Name(), ID: result.Message}, nil
+}
+
+// msg91Report is the payload MSG91 posts to a delivery report webhook
+type msg91Report struct {
+	RequestID string `json:"requestId"`
+	Report    []struct {
+		Number   string `json:"number"`
+		Status   string `json:"status"`
+		Desc     string `json:"desc"`
+		Operator string `json:"operator"`
+	} `json:"report"`
+}
+
+// ParseReceipts authenticates a delivery report via the shared webhook token and reads its final states
+func (p *MSG91) ParseReceipts(r *http.Request, body []byte) ([]Receipt, error) {
+	if err := verifySharedToken(r, p.webhookSecret); err != nil {
+		return nil, err
+	}
+
+	// MSG91 posts either raw JSON or a form with the JSON in its "data" field
+	data := body
+	if form, err := url.ParseQuery(string(body)); err == nil && form.Get("data") != "" {
+		data = []byte(form.Get("data"))
+	}
+
+	var reports []msg91Report
+	if err := json.Unmarshal(data, &reports); err != nil {
+		return nil, fmt.Errorf("unreadable MSG91 delivery report: %v", err)
+	}
+
+	var receipts []Receipt
+	for _, report := range reports {
+		for _, entry := range report.Report {
+			var status string
+			switch entry.Status {
+			case "1":
+				status = ReceiptDelivered
+			case "2", "9", "16", "17", "25", "26":
+				status = ReceiptFailed
+			default:
+				continue
+			}
+
+			receipts = append(receipts, Receipt{
+				MessageID: report.RequestID,
+				Status:    status,
+				Carrier:   entry.Operator,
+				ErrorCode: entry.Desc,
+			})
+		}
+	}
+	return receipts, nil
+}
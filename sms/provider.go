@@ -0,0 +1,129 @@
+package sms
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Provider sends OTP messages through an SMS gateway
+type Provider interface {
+	// Name returns the identifier used to select the provider in configuration
+	Name() string
+	// SendOTP delivers the OTP to the mobile number and returns the gateway's message reference
+	SendOTP(ctx context.Context, mobile string, otp string) (*Message, error)
+}
+
+// Message identifies an SMS accepted by a provider
+type Message struct {
+	Provider string
+	ID       string
+}
+
+// httpClient is shared by all HTTP based providers so that a slow gateway cannot hang a request forever
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// NewProvider builds the provider registered under name using its environment configuration
+func NewProvider(name string) (Provider, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "fast2sms":
+		return NewFast2SMS(os.Getenv("FAST2SMS_API_KEY"))
+	case "twilio":
+		return NewTwilio(os.Getenv("TWILIO_ACCOUNT_SID"), os.Getenv("TWILIO_AUTH_TOKEN"), os.Getenv("TWILIO_FROM_NUMBER"))
+	case "msg91":
+		return NewMSG91(os.Getenv("MSG91_AUTH_KEY"), os.Getenv("MSG91_TEMPLATE_ID"))
+	case "sink":
+		return NewSink(os.Getenv("SMS_SINK_PATH"))
+	default:
+		return nil, fmt.Errorf("unknown SMS provider %q", name)
+	}
+}
+
+// NewProviderFromEnv builds a failover chain from SMS_PROVIDERS, a comma-separated list in priority order.
+// SMS_PROVIDER is used when the list is not set, and Fast2SMS when neither is. The sink provider is
+// refused with ENV=production.
+func NewProviderFromEnv() (Provider, error) {
+	names := os.Getenv("SMS_PROVIDERS")
+	if names == "" {
+		names = os.Getenv("SMS_PROVIDER")
+	}
+
+	var providers []Provider
+	for _, name := range strings.Split(names, ",") {
+		if err := checkSink(name); err != nil {
+			return nil, err
+		}
+		provider, err := NewProvider(name)
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, provider)
+	}
+
+	config := BreakerConfig{
+		FailureThreshold: envInt("SMS_BREAKER_THRESHOLD", 5),
+		Cooldown:         envDuration("SMS_BREAKER_COOLDOWN", 30*time.Second),
+	}
+	return NewFailover(config, providers...)
+}
+
+// checkSink refuses the sink provider in production, since it writes OTPs out in plaintext
+func checkSink(name string) error {
+	if strings.ToLower(strings.TrimSpace(name)) == "sink" && os.Getenv("ENV") == "production" {
+		return fmt.Errorf("the sink provider logs OTPs in plaintext and cannot be used with ENV=production")
+	}
+	return nil
+}
+
+// envInt reads an integer setting, falling back to def when unset or invalid
+func envInt(key string, def int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil {
+		return value
+	}
+	return def
+}
+
+// envDuration reads a duration setting such as "30s", falling back to def when unset or invalid
+func envDuration(key string, def time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil {
+		return value
+	}
+	return def
+}
+
+// Lookup finds the provider with the given name, searching failover chains
+func Lookup(p Provider, name string) (Provider, bool) {
+	if chain, ok := p.(*Failover); ok {
+		return chain.Lookup(name)
+	}
+	if p != nil && p.Name() == name {
+		return p, true
+	}
+	return nil, false
+}
+
+// countryCode returns the dialling code prepended to numbers stored without one
+func countryCode() string {
+	code := strings.TrimPrefix(os.Getenv("SMS_COUNTRY_CODE"), "+")
+	if code == "" {
+		code = "91"
+	}
+	return code
+}
+
+// InternationalNumber converts a stored mobile number into digits including the country code
+func InternationalNumber(mobile string) string {
+	if strings.HasPrefix(mobile, "+") {
+		return strings.TrimPrefix(mobile, "+")
+	}
+	return countryCode() + mobile
+}
+
+// otpMessage is the text body used by providers that do not render templates themselves
+func otpMessage(otp string) string {
+	return fmt.Sprintf("Your verification code is %s. It expires in 5 minutes. Do not share it with anyone.", otp)
+}
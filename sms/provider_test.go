@@ -0,0 +1,27 @@
+package sms
+
+import "testing"
+
+func TestSinkRefusedInProduction(t *testing.T) {
+	t.Setenv("ENV", "production")
+	t.Setenv("SMS_PROVIDERS", "")
+
+	t.Setenv("SMS_PROVIDER", "sink")
+	if _, err := NewProviderFromEnv(); err == nil {
+		t.Error("SMS sink accepted in production")
+	}
+	t.Setenv("SMS_PROVIDERS", "fast2sms,sink")
+	t.Setenv("FAST2SMS_API_KEY", "key")
+	if _, err := NewProviderFromEnv(); err == nil {
+		t.Error("SMS sink accepted in a production failover chain")
+	}
+	t.Setenv("VOICE_PROVIDER", "sink")
+	if _, err := NewVoiceProviderFromEnv(); err == nil {
+		t.Error("voice sink accepted in production")
+	}
+
+	t.Setenv("ENV", "development")
+	if _, err := NewProviderFromEnv(); err != nil {
+		t.Errorf("SMS sink refused in development: %v", err)
+	}
+}
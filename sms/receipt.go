@@ -0,0 +1,78 @@
+package sms
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// Receipt statuses reported by delivery reports
+const (
+	ReceiptDelivered = "delivered"
+	ReceiptFailed    = "failed"
+)
+
+// ErrInvalidSignature is returned when a delivery report cannot be authenticated
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// Receipt is a delivery report for a single message
+type Receipt struct {
+	MessageID string
+	Status    string // ReceiptDelivered or ReceiptFailed
+	Carrier   string
+	ErrorCode string
+}
+
+// ReceiptParser is implemented by providers that post delivery reports (DLRs) to our webhook
+type ReceiptParser interface {
+	// ParseReceipts authenticates the webhook request and extracts its final delivery reports.
+	// Intermediate states such as "queued" or "sent" are omitted.
+	ParseReceipts(r *http.Request, body []byte) ([]Receipt, error)
+}
+
+// webhookSecret returns the shared secret configured for a provider's webhook
+func webhookSecret(provider string) string {
+	return os.Getenv("SMS_WEBHOOK_SECRET_" + strings.ToUpper(provider))
+}
+
+// webhookURL returns the public URL a provider posts delivery reports to, if a base URL is configured
+func webhookURL(provider string) string {
+	base := strings.TrimSuffix(os.Getenv("SMS_WEBHOOK_BASE_URL"), "/")
+	if base == "" {
+		return ""
+	}
+	return base + "/webhooks/sms/" + provider
+}
+
+// requestURL reconstructs the URL the provider called, which some providers include in their signature
+func requestURL(r *http.Request) string {
+	if base := strings.TrimSuffix(os.Getenv("SMS_WEBHOOK_BASE_URL"), "/"); base != "" {
+		return base + r.URL.RequestURI()
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host + r.URL.RequestURI()
+}
+
+// verifySharedToken authenticates callbacks from gateways that do not sign their requests.
+// The secret is configured as a "token" query parameter on the webhook URL registered with the
+// gateway, or sent in the X-Webhook-Token header where the gateway supports custom headers.
+func verifySharedToken(r *http.Request, secret string) error {
+	provided := r.Header.Get("X-Webhook-Token")
+	if provided == "" {
+		provided = r.URL.Query().Get("token")
+	}
+
+	if secret == "" || subtle.ConstantTimeCompare([]byte(provided), []byte(secret)) != 1 {
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package sms
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const twilioAPIBase = "https://api.twilio.com/2010-04-01"
+
+// Twilio sends OTPs through the Twilio Programmable Messaging API
+type Twilio struct {
+	accountSID string
+	authToken  string
+	from       string
+}
+
+// NewTwilio creates a Twilio provider
+func NewTwilio(accountSID, authToken, from string) (*Twilio, error) {
+	if accountSID == "" || authToken == "" || from == "" {
+		return nil, fmt.Errorf("Twilio account SID, auth token and sender number must be set")
+	}
+	return &Twilio{accountSID: accountSID, authToken: authToken, from: from}, nil
+}
+
+// Name returns the provider identifier
+func (p *Twilio) Name() string {
+	return "twilio"
+}
+
+// SendOTP creates a Twilio message containing the OTP
+func (p *Twilio) SendOTP(ctx context.Context, mobile string, otp string) (*Message, error) {
+	form := url.Values{}
+	form.Set("To", "+"+InternationalNumber(mobile))
+	form.Set("From", p.from)
+	form.Set("Body", otpMessage(otp))
+	if callback := webhookURL(p.Name()); callback != "" {
+		form.Set("StatusCallback", callback)
+	}
+
+	endpoint := fmt.Sprintf("%s/Accounts/%s/Messages.json", twilioAPIBase, url.PathEscape(p.accountSID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(p.accountSID, p.authToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Twilio API request failed with status code: %d", resp.StatusCode)
+	}
+
+	var result struct {
+		SID string `json:"sid"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("Twilio returned an unreadable response: %v", err)
+	}
+
+	return &Message{Provider: p.Name(), ID: result.SID}, nil
+}
+
+// ParseReceipts validates the X-Twilio-Signature header and reads a message status callback
+func (p *Twilio) ParseReceipts(r *http.Request, body []byte) ([]Receipt, error) {
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+
+	// Twilio signs the full URL followed by every POST parameter sorted by name
+	keys := make([]string, 0, len(form))
+	for key := range form {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var payload strings.Builder
+	payload.WriteString(requestURL(r))
+	for _, key := range keys {
+		payload.WriteString(key)
+		payload.WriteString(form.Get(key))
+	}
+
+	mac := hmac.New(sha1.New, []byte(p.authToken))
+	mac.Write([]byte(payload.String()))
+	expected := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(r.Header.Get("X-Twilio-Signature"))) != 1 {
+		return nil, ErrInvalidSignature
+	}
+
+	var status string
+	switch form.Get("MessageStatus") {
+	case "delivered":
+		status = ReceiptDelivered
+	case "undelivered", "failed":
+		status = ReceiptFailed
+	default:
+		return nil, nil
+	}
+
+	return []Receipt{{
+		MessageID: form.Get("MessageSid"),
+		Status:    status,
+		ErrorCode: form.Get("ErrorCode"),
+	}}, nil
+}
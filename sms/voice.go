@@ -0,0 +1,98 @@
+package sms
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// NewVoiceProviderFromEnv builds the provider that reads OTPs out in a phone call, selected by
+// VOICE_PROVIDER: "twilio" (using the Twilio SMS credentials) or "sink" (writing to
+// VOICE_SINK_PATH, refused with ENV=production). It returns nil when no voice provider is configured.
+func NewVoiceProviderFromEnv() (Provider, error) {
+	name := strings.ToLower(strings.TrimSpace(os.Getenv("VOICE_PROVIDER")))
+	if err := checkSink(name); err != nil {
+		return nil, err
+	}
+	switch name {
+	case "":
+		return nil, nil
+	case "twilio":
+		return NewTwilioVoice(os.Getenv("TWILIO_ACCOUNT_SID"), os.Getenv("TWILIO_AUTH_TOKEN"), os.Getenv("TWILIO_FROM_NUMBER"))
+	case "sink":
+		return NewSink(os.Getenv("VOICE_SINK_PATH"))
+	default:
+		return nil, fmt.Errorf("unknown voice provider %q", name)
+	}
+}
+
+// TwilioVoice delivers OTPs in a phone call through the Twilio Programmable Voice API
+type TwilioVoice struct {
+	accountSID string
+	authToken  string
+	from       string
+}
+
+// NewTwilioVoice creates a Twilio voice provider
+func NewTwilioVoice(accountSID, authToken, from string) (*TwilioVoice, error) {
+	if accountSID == "" || authToken == "" || from == "" {
+		return nil, fmt.Errorf("Twilio account SID, auth token and caller number must be set")
+	}
+	return &TwilioVoice{accountSID: accountSID, authToken: authToken, from: from}, nil
+}
+
+// Name returns the provider identifier
+func (p *TwilioVoice) Name() string {
+	return "twilio_voice"
+}
+
+// SendOTP places a call that reads the OTP out twice
+func (p *TwilioVoice) SendOTP(ctx context.Context, mobile string, otp string) (*Message, error) {
+	form := url.Values{}
+	form.Set("To", "+"+InternationalNumber(mobile))
+	form.Set("From", p.from)
+	form.Set("Twiml", voiceMessage(otp))
+
+	endpoint := fmt.Sprintf("%s/Accounts/%s/Calls.json", twilioAPIBase, url.PathEscape(p.accountSID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(p.accountSID, p.authToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Twilio API request failed with status code: %d", resp.StatusCode)
+	}
+
+	var result struct {
+		SID string `json:"sid"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("Twilio returned an unreadable response: %v", err)
+	}
+
+	return &Message{Provider: p.Name(), ID: result.SID}, nil
+}
+
+// voiceMessage is the TwiML read out in an OTP call. Characters are spoken one at a time.
+func voiceMessage(otp string) string {
+	spoken := strings.Join(strings.Split(otp, ""), ", ")
+	var text bytes.Buffer
+	xml.EscapeText(&text, []byte(fmt.Sprintf("Your verification code is %s. Again, your code is %s.", spoken, spoken)))
+	return `<Response><Say>` + text.String() + `</Say></Response>`
+}
@@ -0,0 +1,250 @@
+package tokens
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"otp-auth-system/cache"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis keys for refresh tokens and the families they rotate within
+const (
+	tokenPrefix        = "refresh_token:"
+	familyPrefix       = "refresh_family:"
+	userFamiliesPrefix = "refresh_families:"
+)
+
+// Refresh token errors
+var (
+	ErrInvalid = errors.New("invalid or expired refresh token")
+	ErrReused  = errors.New("refresh token reuse detected")
+)
+
+// RefreshTTL is the absolute lifetime of a refresh token family, configured by REFRESH_TOKEN_TTL
+var RefreshTTL = 30 * 24 * time.Hour
+
+// Family describes the chain of refresh tokens created from one login
+type Family struct {
+	ID        string
+	Mobile    string
+	Device    string
+	ExpiresAt time.Time
+}
+
+// checkScript returns the family of an unused refresh token without using it up.
+// Presenting a token that was already used revokes the whole family.
+var checkScript = redis.NewScript(`
+local token = redis.call("HGETALL", KEYS[1])
+if #token == 0 then
+	return {"invalid"}
+end
+local fields = {}
+for i = 1, #token, 2 do
+	fields[token[i]] = token[i + 1]
+end
+
+local familyKey = ARGV[1] .. fields["family"]
+local status = redis.call("HGET", familyKey, "status")
+if status ~= "active" then
+	return {"invalid"}
+end
+
+local family = redis.call("HMGET", familyKey, "mobile", "device", "expires_at")
+if fields["used"] == "1" then
+	redis.call("HSET", familyKey, "status", "revoked")
+	return {"reused", fields["family"], family[1], family[2]}
+end
+return {"ok", fields["family"], family[1], family[2], family[3]}
+`)
+
+// rotateScript marks a refresh token used and stores its successor in one step, so a token is
+// only ever used up once a replacement exists. Reusing a token revokes the whole family.
+var rotateScript = redis.NewScript(`
+local token = redis.call("HMGET", KEYS[1], "family", "used")
+if token[1] ~= ARGV[1] or redis.call("HGET", KEYS[3], "status") ~= "active" then
+	return "invalid"
+end
+if token[2] == "1" then
+	redis.call("HSET", KEYS[3], "status", "revoked")
+	return "reused"
+end
+
+redis.call("HSET", KEYS[1], "used", "1")
+redis.call("HSET", KEYS[2], "family", ARGV[1], "used", "0")
+redis.call("PEXPIREAT", KEYS[2], ARGV[2])
+return "ok"
+`)
+
+// revokeScript revokes a family that still exists, leaving expired families gone
+var revokeScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+	redis.call("HSET", KEYS[1], "status", "revoked")
+end
+return 1
+`)
+
+// Init loads REFRESH_TOKEN_TTL
+func Init() {
+	if value, err := time.ParseDuration(os.Getenv("REFRESH_TOKEN_TTL")); err == nil && value > 0 {
+		RefreshTTL = value
+	}
+}
+
+// Issue starts a new refresh token family for a login and returns its first token
+func Issue(ctx context.Context, mobile, device string) (string, *Family, error) {
+	family := &Family{
+		ID:        uuid.NewString(),
+		Mobile:    mobile,
+		Device:    device,
+		ExpiresAt: time.Now().Add(RefreshTTL),
+	}
+
+	_, err := cache.RDB.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		key := familyPrefix + family.ID
+		pipe.HSet(ctx, key, map[string]interface{}{
+			"mobile":     family.Mobile,
+			"device":     family.Device,
+			"status":     "active",
+			"expires_at": family.ExpiresAt.Unix(),
+		})
+		pipe.ExpireAt(ctx, key, family.ExpiresAt)
+		pipe.SAdd(ctx, userFamiliesPrefix+mobile, family.ID)
+		pipe.Expire(ctx, userFamiliesPrefix+mobile, RefreshTTL)
+		return nil
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	token, err := newToken(ctx, family)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, family, nil
+}
+
+// Check returns the family of a refresh token that can still be rotated, without using it up.
+// Presenting a token that was already used revokes its family and returns ErrReused along with
+// the family, so the caller can end everything issued from it.
+func Check(ctx context.Context, token string) (*Family, error) {
+	result, err := checkScript.Run(ctx, cache.RDB, []string{tokenPrefix + hashToken(token)}, familyPrefix).StringSlice()
+	if err != nil {
+		return nil, err
+	}
+
+	switch result[0] {
+	case "reused":
+		return &Family{ID: result[1], Mobile: result[2], Device: result[3]}, ErrReused
+	case "ok":
+	default:
+		return nil, ErrInvalid
+	}
+
+	expiresAt, _ := strconv.ParseInt(result[4], 10, 64)
+	family := &Family{
+		ID:        result[1],
+		Mobile:    result[2],
+		Device:    result[3],
+		ExpiresAt: time.Unix(expiresAt, 0),
+	}
+	if !time.Now().Before(family.ExpiresAt) {
+		return nil, ErrInvalid
+	}
+	return family, nil
+}
+
+// Rotate exchanges a refresh token checked with Check for a new one in the same family. A token
+// can only be used once; if it was used since the check, the family is revoked and ErrReused is
+// returned. Until Rotate succeeds the token stays usable, so failures before it can be retried.
+func Rotate(ctx context.Context, token string, family *Family) (string, error) {
+	next, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+
+	keys := []string{tokenPrefix + hashToken(token), tokenPrefix + hashToken(next), familyPrefix + family.ID}
+	result, err := rotateScript.Run(ctx, cache.RDB, keys, family.ID, family.ExpiresAt.UnixMilli()).Text()
+	if err != nil {
+		return "", err
+	}
+
+	switch result {
+	case "ok":
+		return next, nil
+	case "reused":
+		return "", ErrReused
+	default:
+		return "", ErrInvalid
+	}
+}
+
+// RevokeFamily revokes every refresh token in a family
+func RevokeFamily(ctx context.Context, familyID string) error {
+	return RevokeFamilyIn(ctx, cache.RDB, familyID)
+}
+
+// RevokeFamilyIn revokes a family using the given client or transaction
+func RevokeFamilyIn(ctx context.Context, rdb redis.Scripter, familyID string) error {
+	return revokeScript.Eval(ctx, rdb, []string{familyPrefix + familyID}).Err()
+}
+
+// RevokeAll revokes every refresh token family of a user
+func RevokeAll(ctx context.Context, mobile string) error {
+	families, err := cache.RDB.SMembers(ctx, userFamiliesPrefix+mobile).Result()
+	if err != nil {
+		return err
+	}
+	for _, familyID := range families {
+		if err := RevokeFamily(ctx, familyID); err != nil {
+			return err
+		}
+	}
+	return cache.RDB.Del(ctx, userFamiliesPrefix+mobile).Err()
+}
+
+// newToken creates an opaque refresh token in the family. Only its hash is stored.
+func newToken(ctx context.Context, family *Family) (string, error) {
+	token, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+
+	key := tokenPrefix + hashToken(token)
+	_, err = cache.RDB.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, map[string]interface{}{
+			"family": family.ID,
+			"used":   "0",
+		})
+		pipe.ExpireAt(ctx, key, family.ExpiresAt)
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// generateToken returns a random opaque refresh token
+func generateToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// hashToken returns the storage key for a refresh token, so Redis never holds usable tokens
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
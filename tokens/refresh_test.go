@@ -0,0 +1,182 @@
+package tokens
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"otp-auth-system/cache"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	testMobile = "+919876543210"
+	testDevice = "device-1"
+)
+
+// useMiniredis points the cache at a fresh in-memory Redis
+func useMiniredis(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+	server := miniredis.RunT(t)
+	cache.RDB = redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { cache.RDB.Close() })
+	return server
+}
+
+// rotate checks and rotates a refresh token
+func rotate(ctx context.Context, token string) (string, *Family, error) {
+	family, err := Check(ctx, token)
+	if err != nil {
+		return "", family, err
+	}
+	next, err := Rotate(ctx, token, family)
+	return next, family, err
+}
+
+func TestRotateDetectsReuse(t *testing.T) {
+	useMiniredis(t)
+	ctx := context.Background()
+
+	first, family, err := Issue(ctx, testMobile, testDevice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, rotated, err := rotate(ctx, first)
+	if err != nil {
+		t.Fatalf("rotating a fresh token: %v", err)
+	}
+	if rotated.ID != family.ID || rotated.Mobile != testMobile || rotated.Device != testDevice {
+		t.Errorf("rotated family = %+v, want %+v", rotated, family)
+	}
+
+	// Replaying the used token revokes the family and names it, so its session can be ended
+	_, reused, err := rotate(ctx, first)
+	if !errors.Is(err, ErrReused) {
+		t.Fatalf("replayed token = %v, want ErrReused", err)
+	}
+	if reused.ID != family.ID || reused.Mobile != testMobile || reused.Device != testDevice {
+		t.Errorf("reused family = %+v, want %+v", reused, family)
+	}
+	if _, _, err := rotate(ctx, second); !errors.Is(err, ErrInvalid) {
+		t.Errorf("latest token after reuse = %v, want ErrInvalid", err)
+	}
+}
+
+func TestCheckLeavesTheTokenUsable(t *testing.T) {
+	useMiniredis(t)
+	ctx := context.Background()
+
+	token, _, err := Issue(ctx, testMobile, testDevice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A refresh that fails after the check can be retried with the same token
+	for i := 0; i < 2; i++ {
+		if _, err := Check(ctx, token); err != nil {
+			t.Fatalf("check %d: %v", i+1, err)
+		}
+	}
+	if _, _, err := rotate(ctx, token); err != nil {
+		t.Errorf("rotating after repeated checks: %v", err)
+	}
+}
+
+func TestRotateDetectsConcurrentUse(t *testing.T) {
+	useMiniredis(t)
+	ctx := context.Background()
+
+	token, _, err := Issue(ctx, testMobile, testDevice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two refreshes with the same token pass the check, but only the first may rotate
+	first, err := Check(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := Check(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	next, err := Rotate(ctx, token, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Rotate(ctx, token, second); !errors.Is(err, ErrReused) {
+		t.Errorf("second rotation = %v, want ErrReused", err)
+	}
+	if _, _, err := rotate(ctx, next); !errors.Is(err, ErrInvalid) {
+		t.Errorf("successor after reuse = %v, want ErrInvalid", err)
+	}
+}
+
+func TestRotateRejectsUnknownTokens(t *testing.T) {
+	useMiniredis(t)
+
+	if _, err := Check(context.Background(), "not-a-token"); !errors.Is(err, ErrInvalid) {
+		t.Errorf("unknown token = %v, want ErrInvalid", err)
+	}
+	family := &Family{ID: "unknown", ExpiresAt: time.Now().Add(time.Hour)}
+	if _, err := Rotate(context.Background(), "not-a-token", family); !errors.Is(err, ErrInvalid) {
+		t.Errorf("rotating an unknown token = %v, want ErrInvalid", err)
+	}
+}
+
+func TestRevokeFamily(t *testing.T) {
+	server := useMiniredis(t)
+	ctx := context.Background()
+
+	token, family, err := Issue(ctx, testMobile, testDevice)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := RevokeFamily(ctx, family.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := rotate(ctx, token); !errors.Is(err, ErrInvalid) {
+		t.Errorf("token of a revoked family = %v, want ErrInvalid", err)
+	}
+
+	// Revoking a family that has expired does not bring it back
+	if err := RevokeFamily(ctx, "expired"); err != nil {
+		t.Fatal(err)
+	}
+	if server.Exists(familyPrefix + "expired") {
+		t.Error("revoking an expired family recreated it")
+	}
+}
+
+func TestRevokeAll(t *testing.T) {
+	useMiniredis(t)
+	ctx := context.Background()
+
+	var tokens []string
+	for _, device := range []string{"device-1", "device-2"} {
+		token, _, err := Issue(ctx, testMobile, device)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, token)
+	}
+	other, _, err := Issue(ctx, "+919876543211", testDevice)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RevokeAll(ctx, testMobile); err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range tokens {
+		if _, _, err := rotate(ctx, token); !errors.Is(err, ErrInvalid) {
+			t.Errorf("token after RevokeAll = %v, want ErrInvalid", err)
+		}
+	}
+	if _, _, err := rotate(ctx, other); err != nil {
+		t.Errorf("another user's token after RevokeAll: %v", err)
+	}
+}
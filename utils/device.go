@@ -3,13 +3,18 @@ package utils
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"net"
 	"net/http"
 )
 
-// GenerateFingerprint creates a unique hash for a device
+// GenerateFingerprint hashes the User-Agent and client address of a request. It changes with the
+// network, so it is a risk signal only; devices are identified by their issued device ID.
 func GenerateFingerprint(r *http.Request) string {
 	userAgent := r.Header.Get("User-Agent")
 	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		ip = host // Leave out the ephemeral port
+	}
 
 	rawData := userAgent + ip
 	hash := sha256.Sum256([]byte(rawData))
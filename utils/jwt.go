@@ -1,45 +1,133 @@
 package utils
 
 import (
+	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
 )
 
-var jwtSecret = []byte(os.Getenv("JWT_SECRET"))
+// AccessTokenTTL is the lifetime of access tokens, configured by ACCESS_TOKEN_TTL
+var AccessTokenTTL = 15 * time.Minute
+
+// Issuer and audiences written to and required in every token, configured by JWT_ISSUER and JWT_AUDIENCE
+var (
+	tokenIssuer    = "otp-auth-system"
+	tokenAudiences = []string{"otp-auth-api"}
+)
 
 type Claims struct {
-	Mobile string `json:"mobile"`
+	Mobile    string `json:"mobile"`
+	SessionID string `json:"sid"`
+	DeviceID  string `json:"did"`
 	jwt.RegisteredClaims
 }
 
-// GenerateJWT creates a new JWT token
-func GenerateJWT(mobile string) (string, error) {
-	expirationTime := time.Now().Add(24 * time.Hour) // Token valid for 24 hours
+// TokenSubject identifies who and what an access token is issued to
+type TokenSubject struct {
+	UserID    string
+	Mobile    string
+	SessionID string
+	DeviceID  string
+}
+
+// InitJWT loads the signing key ring and token settings once environment variables are available.
+// Keys come from JWT_KEYS_DIR when it is set, otherwise from the environment: JWT_SIGNING_ALG
+// selects HS256 (default, signed with JWT_SECRET), RS256, ES256 or EdDSA, and asymmetric
+// algorithms read a PEM private key from JWT_PRIVATE_KEY or JWT_PRIVATE_KEY_FILE.
+func InitJWT() error {
+	if value, err := time.ParseDuration(os.Getenv("ACCESS_TOKEN_TTL")); err == nil && value > 0 {
+		AccessTokenTTL = value
+	}
+	TrustedDeviceTTL = envDuration("TRUSTED_DEVICE_TTL", TrustedDeviceTTL)
+
+	if issuer := os.Getenv("JWT_ISSUER"); issuer != "" {
+		tokenIssuer = issuer
+	}
+	if audience := os.Getenv("JWT_AUDIENCE"); audience != "" {
+		tokenAudiences = strings.Split(audience, ",")
+	}
+
+	keysDir = os.Getenv("JWT_KEYS_DIR")
+	activationDelay = envDuration("JWT_KEY_ACTIVATION_DELAY", activationDelay)
+
+	return loadKeyRing()
+}
+
+// GenerateJWT creates a new access token for the subject
+func GenerateJWT(subject TokenSubject) (string, error) {
+	key := ring.signer()
+	if key == nil {
+		return "", fmt.Errorf("JWT signing key is not configured")
+	}
+
+	now := time.Now()
+	expirationTime := now.Add(AccessTokenTTL) // Short-lived; renewed with a refresh token
 
 	claims := &Claims{
-		Mobile: mobile,
+		Mobile:    subject.Mobile,
+		SessionID: subject.SessionID,
+		DeviceID:  subject.DeviceID,
 		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer,
+			Subject:   subject.UserID,
+			Audience:  tokenAudiences,
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        uuid.NewString(),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.private)
 }
 
-// ValidateJWT parses and validates a JWT token
+// ValidateJWT parses and validates a JWT token against every key in the ring.
+// The issuer, audience and expiry are enforced and tokens missing required claims are rejected.
 func ValidateJWT(tokenString string) (*Claims, error) {
 	claims := &Claims{}
 
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
+	token, err := jwt.ParseWithClaims(tokenString, claims, ringKey,
+		jwt.WithValidMethods([]string{"HS256", "RS256", "ES256", "EdDSA"}),
+		jwt.WithIssuer(tokenIssuer),
+		jwt.WithAudience(tokenAudiences[0]),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
 
 	if err != nil || !token.Valid {
 		return nil, err
 	}
 
+	if claims.Subject == "" || claims.ID == "" || claims.Mobile == "" || claims.SessionID == "" || claims.DeviceID == "" {
+		return nil, fmt.Errorf("token is missing required claims")
+	}
+
 	return claims, nil
 }
+
+// ringKey returns the ring key that verifies a token. A token must use the algorithm of the
+// key it names, which rules out "none" and algorithm confusion.
+func ringKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ring.verifier(kid)
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+	return key.public, nil
+}
+
+// JWKS returns the public keys that verify tokens, for publishing at /.well-known/jwks.json.
+// Pending keys are included so verifiers learn them before they sign anything. Shared HS256
+// secrets are never published.
+func JWKS() []JWK {
+	return ring.publicKeys()
+}
@@ -0,0 +1,480 @@
+package utils
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"otp-auth-system/cache"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Key statuses reported by SigningKeys
+const (
+	KeyPending = "pending" // Published in the JWKS but not yet used for signing
+	KeyActive  = "active"  // Signs new tokens
+	KeyRetired = "retired" // Only verifies tokens issued before it was replaced
+)
+
+// keyFileTimeFormat names key files after their creation time so they sort chronologically
+const keyFileTimeFormat = "20060102T150405.000000000Z"
+
+// KeyInfo describes a key in the ring
+type KeyInfo struct {
+	Kid       string     `json:"kid"`
+	Alg       string     `json:"alg"`
+	Status    string     `json:"status"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	ActiveAt  *time.Time `json:"active_at,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"` // When a retired key stops verifying tokens
+}
+
+// keyRing holds the key that signs new tokens and the keys that still verify older ones
+type keyRing struct {
+	mu      sync.RWMutex
+	current *signingKey
+	keys    map[string]*signingKey
+	infos   []KeyInfo
+}
+
+// ring is the process-wide key ring, loaded by InitJWT
+var ring = &keyRing{keys: map[string]*signingKey{}}
+
+// keyRotationLock is held in Redis by the instance performing a scheduled rotation
+const (
+	keyRotationLock    = "jwt_key_rotation_lock"
+	keyRotationLockTTL = time.Minute
+)
+
+// Key ring settings
+var (
+	keysDir         string
+	activationDelay = 10 * time.Minute
+)
+
+// signer returns the key new tokens are signed with
+func (r *keyRing) signer() *signingKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.current
+}
+
+// verifier returns the key with the given ID. Tokens without a key ID predate key IDs and
+// can only have been signed with an HS256 secret.
+func (r *keyRing) verifier(kid string) (*signingKey, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if kid == "" {
+		for _, key := range r.keys {
+			if key.method == jwt.SigningMethodHS256 {
+				return key, true
+			}
+		}
+		return nil, false
+	}
+	key, ok := r.keys[kid]
+	return key, ok
+}
+
+// publicKeys returns the JWKs of every asymmetric key in the ring
+func (r *keyRing) publicKeys() []JWK {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	keys := []JWK{}
+	for _, info := range r.infos {
+		if key, ok := r.keys[info.Kid].jwk(); ok {
+			keys = append(keys, *key)
+		}
+	}
+	return keys
+}
+
+// replace swaps in a newly loaded set of keys
+func (r *keyRing) replace(current *signingKey, keys map[string]*signingKey, infos []KeyInfo) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.current, r.keys, r.infos = current, keys, infos
+}
+
+// SigningKeys lists the keys in the ring, oldest first
+func SigningKeys() []KeyInfo {
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+	return append([]KeyInfo(nil), ring.infos...)
+}
+
+// loadKeyRing loads the ring from JWT_KEYS_DIR when it is set, otherwise from the environment
+func loadKeyRing() error {
+	if keysDir != "" {
+		return loadKeysFromDir()
+	}
+	return loadKeysFromEnv()
+}
+
+// loadKeysFromEnv builds the ring from the configured signing key plus retired keys.
+// JWT_RETIRED_KEYS holds PEM private keys and JWT_RETIRED_SECRETS comma-separated HS256 secrets;
+// they verify tokens until they are removed from the environment.
+func loadKeysFromEnv() error {
+	method, err := signingMethod(os.Getenv("JWT_SIGNING_ALG"))
+	if err != nil {
+		return err
+	}
+
+	var current *signingKey
+	if method == jwt.SigningMethodHS256 {
+		current, err = newSecretKey([]byte(os.Getenv("JWT_SECRET")))
+	} else {
+		pemData := []byte(os.Getenv("JWT_PRIVATE_KEY"))
+		if path := os.Getenv("JWT_PRIVATE_KEY_FILE"); len(pemData) == 0 && path != "" {
+			if pemData, err = os.ReadFile(path); err != nil {
+				return fmt.Errorf("failed to read JWT private key: %v", err)
+			}
+		}
+		if len(pemData) == 0 {
+			return fmt.Errorf("JWT_PRIVATE_KEY or JWT_PRIVATE_KEY_FILE is required for %s", method.Alg())
+		}
+		current, err = parsePrivateKey(method, pemData)
+	}
+	if err != nil {
+		return err
+	}
+
+	var retired []*signingKey
+	rest := []byte(os.Getenv("JWT_RETIRED_KEYS"))
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		key, err := parseAnyPrivateKey(pem.EncodeToMemory(block))
+		if err != nil {
+			return fmt.Errorf("invalid key in JWT_RETIRED_KEYS: %v", err)
+		}
+		retired = append(retired, key)
+	}
+	for _, secret := range strings.Split(os.Getenv("JWT_RETIRED_SECRETS"), ",") {
+		if secret = strings.TrimSpace(secret); secret != "" {
+			key, _ := newSecretKey([]byte(secret))
+			retired = append(retired, key)
+		}
+	}
+
+	keys := map[string]*signingKey{}
+	var infos []KeyInfo
+	for _, key := range retired {
+		keys[key.kid] = key
+		infos = append(infos, KeyInfo{Kid: key.kid, Alg: key.method.Alg(), Status: KeyRetired})
+	}
+	keys[current.kid] = current
+	infos = append(infos, KeyInfo{Kid: current.kid, Alg: current.method.Alg(), Status: KeyActive})
+
+	ring.replace(current, keys, infos)
+	return nil
+}
+
+// dirKey is a key file read from JWT_KEYS_DIR
+type dirKey struct {
+	key       *signingKey
+	createdAt time.Time
+}
+
+// readKeyDir reads every key file in JWT_KEYS_DIR, oldest first.
+// PEM files (*.pem) hold asymmetric private keys and *.key files hold HS256 secrets.
+func readKeyDir() ([]dirKey, error) {
+	entries, err := os.ReadDir(keysDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWT_KEYS_DIR: %v", err)
+	}
+
+	var keys []dirKey
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || (ext != ".pem" && ext != ".key") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(keysDir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		var key *signingKey
+		if ext == ".key" {
+			key, err = newSecretKey([]byte(strings.TrimSpace(string(data))))
+		} else {
+			key, err = parseAnyPrivateKey(data)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid key file %s: %v", entry.Name(), err)
+		}
+
+		createdAt, err := time.Parse(keyFileTimeFormat, strings.TrimSuffix(entry.Name(), ext))
+		if err != nil {
+			info, err := entry.Info()
+			if err != nil {
+				return nil, err
+			}
+			createdAt = info.ModTime()
+		}
+		keys = append(keys, dirKey{key: key, createdAt: createdAt})
+	}
+
+	sort.Slice(keys, func(i, j int) bool { return keys[i].createdAt.Before(keys[j].createdAt) })
+	return keys, nil
+}
+
+// loadKeysFromDir builds the ring from JWT_KEYS_DIR. A key starts signing JWT_KEY_ACTIVATION_DELAY
+// after it was created, so verifiers can fetch it from the JWKS first. Once replaced it keeps
+// verifying tokens for their maximum lifetime.
+func loadKeysFromDir() error {
+	files, err := readKeyDir()
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no signing keys found in %s, run \"rotate-keys\" to create one", keysDir)
+	}
+
+	now := time.Now()
+
+	// The newest key past its activation time signs; with none active yet the oldest key bootstraps the ring
+	currentIndex := 0
+	for i, file := range files {
+		if !file.createdAt.Add(activationDelay).After(now) {
+			currentIndex = i
+		}
+	}
+
+	keys := map[string]*signingKey{}
+	var infos []KeyInfo
+	for i, file := range files {
+		createdAt := file.createdAt
+		activeAt := createdAt.Add(activationDelay)
+		info := KeyInfo{Kid: file.key.kid, Alg: file.key.method.Alg(), CreatedAt: &createdAt, ActiveAt: &activeAt}
+
+		switch {
+		case i == currentIndex:
+			info.Status = KeyActive
+		case i > currentIndex:
+			info.Status = KeyPending
+		default:
+			// Retired when its successor became active; tokens it signed expire MaxTokenLifetime later
+			expiresAt := files[i+1].createdAt.Add(activationDelay).Add(MaxTokenLifetime())
+			if !expiresAt.After(now) {
+				continue
+			}
+			info.Status = KeyRetired
+			info.ExpiresAt = &expiresAt
+		}
+
+		keys[file.key.kid] = file.key
+		infos = append(infos, info)
+	}
+
+	ring.replace(files[currentIndex].key, keys, infos)
+	return nil
+}
+
+// MaxTokenLifetime is the longest lifetime of any token signed by the ring. Trusted device
+// credentials usually outlive access tokens, so retired keys are kept until they expire too.
+func MaxTokenLifetime() time.Duration {
+	if TrustedDeviceTTL > AccessTokenTTL {
+		return TrustedDeviceTTL
+	}
+	return AccessTokenTTL
+}
+
+// RotateKeys creates a new signing key in JWT_KEYS_DIR using JWT_SIGNING_ALG, removes keys whose
+// tokens have all expired and reloads the ring. It returns the new key ID.
+func RotateKeys() (string, error) {
+	if keysDir == "" {
+		return "", fmt.Errorf("key rotation requires JWT_KEYS_DIR")
+	}
+
+	method, err := signingMethod(os.Getenv("JWT_SIGNING_ALG"))
+	if err != nil {
+		return "", err
+	}
+
+	data, ext, err := generateKey(method)
+	if err != nil {
+		return "", err
+	}
+
+	// Write to a temporary file first so a concurrent reload never sees a partial key
+	name := time.Now().UTC().Format(keyFileTimeFormat) + ext
+	tmp, err := os.CreateTemp(keysDir, ".rotate-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(keysDir, name)); err != nil {
+		return "", err
+	}
+
+	if err := pruneKeyDir(); err != nil {
+		log.Printf("Failed to prune expired signing keys: %v", err)
+	}
+	if err := loadKeysFromDir(); err != nil {
+		return "", err
+	}
+
+	var key *signingKey
+	if ext == ".key" {
+		key, err = newSecretKey(data)
+	} else {
+		key, err = parseAnyPrivateKey(data)
+	}
+	if err != nil {
+		return "", err
+	}
+	return key.kid, nil
+}
+
+// pruneKeyDir deletes key files that no longer verify any unexpired token
+func pruneKeyDir() error {
+	files, err := readKeyDir()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for i := 0; i+1 < len(files); i++ {
+		successorActive := files[i+1].createdAt.Add(activationDelay)
+		if successorActive.Add(MaxTokenLifetime()).After(now) {
+			continue
+		}
+
+		base := files[i].createdAt.UTC().Format(keyFileTimeFormat)
+		for _, ext := range []string{".pem", ".key"} {
+			path := filepath.Join(keysDir, base+ext)
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// StartKeyRotation reloads JWT_KEYS_DIR every JWT_KEYS_RELOAD_INTERVAL (default 1m) so all instances
+// pick up rotated keys, and creates a new key whenever the newest one is older than
+// JWT_KEY_ROTATION_INTERVAL, if set
+func StartKeyRotation() {
+	if keysDir == "" {
+		return
+	}
+
+	reloadInterval := envDuration("JWT_KEYS_RELOAD_INTERVAL", time.Minute)
+	rotationInterval := envDuration("JWT_KEY_ROTATION_INTERVAL", 0)
+
+	go func() {
+		for {
+			time.Sleep(reloadInterval)
+
+			if rotationInterval > 0 && rotationDue(rotationInterval) {
+				rotated, err := rotateOnce(rotationInterval)
+				if err != nil {
+					log.Printf("Scheduled signing key rotation failed: %v", err)
+				}
+				if rotated {
+					continue
+				}
+			}
+
+			if err := loadKeysFromDir(); err != nil {
+				log.Printf("Failed to reload signing keys: %v", err)
+			}
+		}
+	}()
+}
+
+// rotationDue reports whether the newest key in JWT_KEYS_DIR is older than the rotation interval
+func rotationDue(interval time.Duration) bool {
+	files, err := readKeyDir()
+	return err == nil && len(files) > 0 && time.Since(files[len(files)-1].createdAt) >= interval
+}
+
+// rotateOnce rotates the keys unless another instance is already doing so. Instances share
+// JWT_KEYS_DIR, so a Redis lock makes sure only one creates a key; the age is checked again while
+// holding it in case another instance rotated just before. The lock is left to expire so instances
+// that saw the old key wait until the new one is on disk.
+func rotateOnce(interval time.Duration) (bool, error) {
+	locked, err := cache.RDB.SetNX(context.Background(), keyRotationLock, "1", keyRotationLockTTL).Result()
+	if err != nil || !locked {
+		return false, err
+	}
+	if !rotationDue(interval) {
+		return false, nil
+	}
+
+	kid, err := RotateKeys()
+	if err != nil {
+		return false, err
+	}
+	fmt.Printf("Rotated signing key, new key %s\n", kid)
+	return true, nil
+}
+
+// generateKey creates a new private key for the algorithm, returning its file contents and extension
+func generateKey(method jwt.SigningMethod) ([]byte, string, error) {
+	if method == jwt.SigningMethodHS256 {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, "", err
+		}
+		return []byte(base64.RawURLEncoding.EncodeToString(secret)), ".key", nil
+	}
+
+	var private interface{}
+	var err error
+	switch method {
+	case jwt.SigningMethodRS256:
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case jwt.SigningMethodES256:
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, "", err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), ".pem", nil
+}
+
+// envDuration reads a duration setting, falling back to def when unset or invalid
+func envDuration(key string, def time.Duration) time.Duration {
+	if value, err := time.ParseDuration(os.Getenv(key)); err == nil && value >= 0 {
+		return value
+	}
+	return def
+}
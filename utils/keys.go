@@ -0,0 +1,185 @@
+package utils
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// signingKey is a key tokens are signed or verified with
+type signingKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private interface{} // []byte for HS256, otherwise a crypto.Signer
+	public  interface{} // []byte for HS256, otherwise a crypto.PublicKey
+}
+
+// JWK is the public JSON Web Key representation of a signing key
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// signingMethod resolves a configured algorithm name
+func signingMethod(alg string) (jwt.SigningMethod, error) {
+	switch strings.ToUpper(alg) {
+	case "", "HS256":
+		return jwt.SigningMethodHS256, nil
+	case "RS256":
+		return jwt.SigningMethodRS256, nil
+	case "ES256":
+		return jwt.SigningMethodES256, nil
+	case "EDDSA":
+		return jwt.SigningMethodEdDSA, nil
+	default:
+		return nil, fmt.Errorf("unsupported JWT signing algorithm %q", alg)
+	}
+}
+
+// newSecretKey wraps an HS256 shared secret
+func newSecretKey(secret []byte) (*signingKey, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("JWT_SECRET is not set")
+	}
+	sum := sha256.Sum256(secret)
+	return &signingKey{
+		kid:     "hs256-" + base64.RawURLEncoding.EncodeToString(sum[:8]),
+		method:  jwt.SigningMethodHS256,
+		private: secret,
+		public:  secret,
+	}, nil
+}
+
+// parsePrivateKey reads a PEM encoded private key for an asymmetric algorithm.
+// The key ID is derived from the public key, so it is stable across restarts.
+func parsePrivateKey(method jwt.SigningMethod, data []byte) (*signingKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("JWT private key is not PEM encoded")
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		parsed, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWT private key: %v", err)
+	}
+
+	signer, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("JWT private key cannot sign")
+	}
+
+	switch key := signer.(type) {
+	case *rsa.PrivateKey:
+		if method != jwt.SigningMethodRS256 {
+			return nil, fmt.Errorf("RSA key cannot be used with %s", method.Alg())
+		}
+		if key.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("RSA key must be at least 2048 bits")
+		}
+	case *ecdsa.PrivateKey:
+		if method != jwt.SigningMethodES256 || key.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("ECDSA key must use P-256 with ES256")
+		}
+	case ed25519.PrivateKey:
+		if method != jwt.SigningMethodEdDSA {
+			return nil, fmt.Errorf("Ed25519 key cannot be used with %s", method.Alg())
+		}
+	default:
+		return nil, fmt.Errorf("unsupported JWT private key type %T", signer)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(der)
+
+	return &signingKey{
+		kid:     base64.RawURLEncoding.EncodeToString(sum[:16]),
+		method:  method,
+		private: signer,
+		public:  signer.Public(),
+	}, nil
+}
+
+// parseAnyPrivateKey reads a PEM private key and infers its algorithm from the key type
+func parseAnyPrivateKey(data []byte) (*signingKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("JWT private key is not PEM encoded")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return parsePrivateKey(jwt.SigningMethodRS256, data)
+	case "EC PRIVATE KEY":
+		return parsePrivateKey(jwt.SigningMethodES256, data)
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWT private key: %v", err)
+	}
+	switch parsed.(type) {
+	case *rsa.PrivateKey:
+		return parsePrivateKey(jwt.SigningMethodRS256, data)
+	case *ecdsa.PrivateKey:
+		return parsePrivateKey(jwt.SigningMethodES256, data)
+	case ed25519.PrivateKey:
+		return parsePrivateKey(jwt.SigningMethodEdDSA, data)
+	default:
+		return nil, fmt.Errorf("unsupported JWT private key type %T", parsed)
+	}
+}
+
+// jwk returns the public JWK for an asymmetric key; shared secrets are never published
+func (k *signingKey) jwk() (*JWK, bool) {
+	key := &JWK{Use: "sig", Alg: k.method.Alg(), Kid: k.kid}
+
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		key.Kty = "RSA"
+		key.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		key.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		key.Kty = "EC"
+		key.Crv = pub.Curve.Params().Name
+		key.X = base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size)))
+		key.Y = base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size)))
+	case ed25519.PublicKey:
+		key.Kty = "OKP"
+		key.Crv = "Ed25519"
+		key.X = base64.RawURLEncoding.EncodeToString(pub)
+	default:
+		return nil, false
+	}
+	return key, true
+}
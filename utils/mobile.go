@@ -0,0 +1,13 @@
+package utils
+
+import "regexp"
+
+// mobilePattern matches an E.164 number ("+919876543210") or a national number stored without its
+// country code ("9876543210"): up to 15 digits, not starting with 0
+var mobilePattern = regexp.MustCompile(`^\+?[1-9][0-9]{6,14}$`)
+
+// ValidMobile reports whether a client-supplied mobile number is well formed. Mobile numbers are
+// used in Redis keys, so anything else must be rejected before it reaches them.
+func ValidMobile(mobile string) bool {
+	return mobilePattern.MatchString(mobile)
+}
@@ -1,12 +1,117 @@
 package utils
 
 import (
+	"crypto/rand"
 	"fmt"
-	"math/rand"
-	"time"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
 )
 
-func GenerateOTP() string {
-	rand.Seed(time.Now().UnixNano())
-	return fmt.Sprintf("%06d", rand.Intn(1000000))
+// OTP alphabets selectable through OTP_ALPHABET
+const (
+	AlphabetNumeric      = "0123456789"
+	AlphabetAlphanumeric = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	AlphabetUnambiguous  = "23456789ABCDEFGHJKMNPQRSTUVWXYZ" // Omits 0/O, 1/I/L which are easily confused
+)
+
+// OTP length bounds
+const (
+	MinOTPLength = 4
+	MaxOTPLength = 10
+)
+
+// OTPGenerator produces uniformly distributed OTPs from a cryptographically secure source
+type OTPGenerator struct {
+	length   int
+	alphabet string
+	max      *big.Int
+}
+
+// otpGenerator is used by GenerateOTP; it defaults to 6 numeric digits until InitOTPGenerator runs
+var otpGenerator = &OTPGenerator{length: 6, alphabet: AlphabetNumeric, max: big.NewInt(int64(len(AlphabetNumeric)))}
+
+// NewOTPGenerator creates a generator for codes of the given length drawn from alphabet
+func NewOTPGenerator(length int, alphabet string) (*OTPGenerator, error) {
+	if length < MinOTPLength || length > MaxOTPLength {
+		return nil, fmt.Errorf("OTP length must be between %d and %d, got %d", MinOTPLength, MaxOTPLength, length)
+	}
+	if len(alphabet) < 2 {
+		return nil, fmt.Errorf("OTP alphabet must contain at least two characters")
+	}
+
+	seen := make(map[rune]bool, len(alphabet))
+	for _, ch := range alphabet {
+		if ch > 127 {
+			return nil, fmt.Errorf("OTP alphabet must be ASCII")
+		}
+		if seen[ch] {
+			return nil, fmt.Errorf("OTP alphabet contains duplicate character %q", ch)
+		}
+		seen[ch] = true
+	}
+
+	return &OTPGenerator{length: length, alphabet: alphabet, max: big.NewInt(int64(len(alphabet)))}, nil
+}
+
+// Generate returns a new OTP. Each character is drawn independently with rand.Int,
+// which rejects out-of-range samples instead of taking a modulo, so every character is equally likely.
+func (g *OTPGenerator) Generate() (string, error) {
+	otp := make([]byte, g.length)
+	for i := range otp {
+		n, err := rand.Int(rand.Reader, g.max)
+		if err != nil {
+			return "", fmt.Errorf("failed to read random source: %v", err)
+		}
+		otp[i] = g.alphabet[n.Int64()]
+	}
+	return string(otp), nil
+}
+
+// Alphabet returns the characters OTPs are drawn from
+func (g *OTPGenerator) Alphabet() string {
+	return g.alphabet
+}
+
+// InitOTPGenerator configures GenerateOTP from OTP_LENGTH (default 6) and
+// OTP_ALPHABET: "numeric" (default), "alphanumeric" or "unambiguous"
+func InitOTPGenerator() error {
+	length := 6
+	if value := os.Getenv("OTP_LENGTH"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid OTP_LENGTH %q", value)
+		}
+		length = parsed
+	}
+
+	var alphabet string
+	switch strings.ToLower(os.Getenv("OTP_ALPHABET")) {
+	case "", "numeric":
+		alphabet = AlphabetNumeric
+	case "alphanumeric":
+		alphabet = AlphabetAlphanumeric
+	case "unambiguous":
+		alphabet = AlphabetUnambiguous
+	default:
+		return fmt.Errorf("invalid OTP_ALPHABET %q", os.Getenv("OTP_ALPHABET"))
+	}
+
+	generator, err := NewOTPGenerator(length, alphabet)
+	if err != nil {
+		return err
+	}
+	otpGenerator = generator
+	return nil
+}
+
+// GenerateOTP returns a new OTP from the configured generator
+func GenerateOTP() (string, error) {
+	return otpGenerator.Generate()
+}
+
+// NormalizeOTP canonicalises user input before comparison; letter alphabets are upper case
+func NormalizeOTP(otp string) string {
+	return strings.ToUpper(strings.TrimSpace(otp))
 }
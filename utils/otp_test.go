@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"crypto/rand"
+	"math"
+	"strings"
+	"testing"
+)
+
+// chiSquareBound is the chi-square value that a uniform source exceeds with probability of
+// about one in a million, using the Wilson-Hilferty approximation
+func chiSquareBound(df int) float64 {
+	const z = 4.75
+	k := float64(df)
+	return k * math.Pow(1-2/(9*k)+z*math.Sqrt(2/(9*k)), 3)
+}
+
+// chiSquare measures how far observed counts are from a uniform distribution over buckets
+func chiSquare(counts []int, samples int) float64 {
+	expected := float64(samples) / float64(len(counts))
+	var sum float64
+	for _, count := range counts {
+		diff := float64(count) - expected
+		sum += diff * diff / expected
+	}
+	return sum
+}
+
+func TestGenerateCharacterDistribution(t *testing.T) {
+	alphabets := map[string]string{
+		"numeric":      AlphabetNumeric,
+		"alphanumeric": AlphabetAlphanumeric,
+		"unambiguous":  AlphabetUnambiguous,
+	}
+	const length, samples = 6, 50000
+
+	for name, alphabet := range alphabets {
+		t.Run(name, func(t *testing.T) {
+			generator, err := NewOTPGenerator(length, alphabet)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// One histogram per position, so a bias at any position is visible on its own
+			counts := make([][]int, length)
+			for i := range counts {
+				counts[i] = make([]int, len(alphabet))
+			}
+			for n := 0; n < samples; n++ {
+				otp, err := generator.Generate()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(otp) != length {
+					t.Fatalf("got OTP %q, want length %d", otp, length)
+				}
+				for i := 0; i < length; i++ {
+					index := strings.IndexByte(alphabet, otp[i])
+					if index < 0 {
+						t.Fatalf("OTP %q contains %q, which is not in the alphabet", otp, otp[i])
+					}
+					counts[i][index]++
+				}
+			}
+
+			bound := chiSquareBound(len(alphabet) - 1)
+			for i, histogram := range counts {
+				if stat := chiSquare(histogram, samples); stat > bound {
+					t.Errorf("position %d: chi-square %.1f exceeds %.1f", i, stat, bound)
+				}
+			}
+		})
+	}
+}
+
+func TestGenerateValueDistribution(t *testing.T) {
+	// Every 4-digit code should be equally likely, not just every digit
+	generator, err := NewOTPGenerator(4, AlphabetNumeric)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const samples = 500000
+
+	counts := make([]int, 10000)
+	for n := 0; n < samples; n++ {
+		otp, err := generator.Generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		value := 0
+		for i := 0; i < len(otp); i++ {
+			value = value*10 + int(otp[i]-'0')
+		}
+		counts[value]++
+	}
+
+	if stat, bound := chiSquare(counts, samples), chiSquareBound(len(counts)-1); stat > bound {
+		t.Errorf("chi-square %.1f exceeds %.1f", stat, bound)
+	}
+}
+
+func TestChiSquareDetectsModuloBias(t *testing.T) {
+	// Reducing a random byte modulo 31 favours the first 8 characters (9/256 against 8/256),
+	// which the bound used above must catch
+	const samples = 50000
+	buffer := make([]byte, samples)
+	if _, err := rand.Read(buffer); err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make([]int, len(AlphabetUnambiguous))
+	for _, b := range buffer {
+		counts[int(b)%len(AlphabetUnambiguous)]++
+	}
+
+	if stat, bound := chiSquare(counts, samples), chiSquareBound(len(counts)-1); stat <= bound {
+		t.Errorf("chi-square %.1f of a modulo-biased source is within %.1f", stat, bound)
+	}
+}
+
+func TestNewOTPGeneratorRejectsInvalidSettings(t *testing.T) {
+	cases := []struct {
+		length   int
+		alphabet string
+	}{
+		{MinOTPLength - 1, AlphabetNumeric},
+		{MaxOTPLength + 1, AlphabetNumeric},
+		{6, "1"},
+		{6, "112"},
+		{6, "12é"},
+	}
+	for _, tc := range cases {
+		if _, err := NewOTPGenerator(tc.length, tc.alphabet); err == nil {
+			t.Errorf("NewOTPGenerator(%d, %q) succeeded, want an error", tc.length, tc.alphabet)
+		}
+	}
+}
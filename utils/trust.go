@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// TrustedDeviceTTL is how long a trusted device may log in without an OTP, configured by
+// TRUSTED_DEVICE_TTL. Zero disables trusted devices.
+var TrustedDeviceTTL = 30 * 24 * time.Hour
+
+// deviceCredentialAudience keeps device credentials and access tokens from being used as each other
+const deviceCredentialAudience = "device-trust"
+
+// DeviceCredentialClaims are the claims of a trusted device credential
+type DeviceCredentialClaims struct {
+	Mobile   string `json:"mobile"`
+	DeviceID string `json:"did"`
+	jwt.RegisteredClaims
+}
+
+// GenerateDeviceCredential signs a credential that lets the device log in as the user without an OTP
+// until it expires. Its ID is stored with the device so the credential can be withdrawn.
+func GenerateDeviceCredential(userID, mobile, deviceID string) (string, *DeviceCredentialClaims, error) {
+	key := ring.signer()
+	if key == nil {
+		return "", nil, fmt.Errorf("JWT signing key is not configured")
+	}
+
+	now := time.Now()
+	claims := &DeviceCredentialClaims{
+		Mobile:   mobile,
+		DeviceID: deviceID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    tokenIssuer,
+			Subject:   userID,
+			Audience:  jwt.ClaimStrings{deviceCredentialAudience},
+			ExpiresAt: jwt.NewNumericDate(now.Add(TrustedDeviceTTL)),
+			NotBefore: jwt.NewNumericDate(now),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ID:        uuid.NewString(),
+		},
+	}
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+	signed, err := token.SignedString(key.private)
+	if err != nil {
+		return "", nil, err
+	}
+	return signed, claims, nil
+}
+
+// ValidateDeviceCredential parses and validates a trusted device credential
+func ValidateDeviceCredential(tokenString string) (*DeviceCredentialClaims, error) {
+	claims := &DeviceCredentialClaims{}
+
+	token, err := jwt.ParseWithClaims(tokenString, claims, ringKey,
+		jwt.WithValidMethods([]string{"HS256", "RS256", "ES256", "EdDSA"}),
+		jwt.WithIssuer(tokenIssuer),
+		jwt.WithAudience(deviceCredentialAudience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+
+	if err != nil || !token.Valid {
+		return nil, err
+	}
+
+	if claims.Subject == "" || claims.ID == "" || claims.Mobile == "" || claims.DeviceID == "" {
+		return nil, fmt.Errorf("credential is missing required claims")
+	}
+
+	return claims, nil
+}
+
+// NewDeviceSecret generates the secret a trusted device presents with its credential, so a leaked
+// credential alone cannot log in. Only the secret's hash is stored.
+func NewDeviceSecret() (secret, hash string, err error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", "", err
+	}
+	secret = base64.RawURLEncoding.EncodeToString(raw)
+	return secret, hashDeviceSecret(secret), nil
+}
+
+// DeviceSecretMatches reports whether the secret is the one whose hash was stored
+func DeviceSecretMatches(secret, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashDeviceSecret(secret)), []byte(hash)) == 1
+}
+
+// hashDeviceSecret returns the hex SHA-256 of a device secret
+func hashDeviceSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
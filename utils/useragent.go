@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"regexp"
+	"strings"
+)
+
+// UserAgent holds the parts of a User-Agent header shown to users
+type UserAgent struct {
+	OS      string
+	Browser string
+}
+
+// Checked in order, since browsers include each other's tokens (Edge claims to be Chrome, Chrome claims to be Safari)
+var (
+	osPatterns = []struct {
+		token string
+		name  string
+	}{
+		{"Windows", "Windows"},
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"CrOS", "ChromeOS"},
+		{"Mac OS X", "macOS"},
+		{"Linux", "Linux"},
+	}
+	browserPatterns = []struct {
+		pattern *regexp.Regexp
+		name    string
+	}{
+		{regexp.MustCompile(`Edg[A-Za-z]*/(\d+)`), "Edge"},
+		{regexp.MustCompile(`OPR/(\d+)`), "Opera"},
+		{regexp.MustCompile(`SamsungBrowser/(\d+)`), "Samsung Internet"},
+		{regexp.MustCompile(`(?:Firefox|FxiOS)/(\d+)`), "Firefox"},
+		{regexp.MustCompile(`(?:Chrome|CriOS)/(\d+)`), "Chrome"},
+		{regexp.MustCompile(`Version/(\d+)[.\d]* (?:Mobile/\S+ )?Safari/`), "Safari"},
+	}
+)
+
+// ParseUserAgent extracts the operating system and browser (with major version) from a User-Agent header.
+// Unrecognised parts are left empty.
+func ParseUserAgent(header string) UserAgent {
+	var ua UserAgent
+	for _, os := range osPatterns {
+		if strings.Contains(header, os.token) {
+			ua.OS = os.name
+			break
+		}
+	}
+	for _, browser := range browserPatterns {
+		if match := browser.pattern.FindStringSubmatch(header); match != nil {
+			ua.Browser = browser.name + " " + match[1]
+			break
+		}
+	}
+	return ua
+}
+
+// DeviceName returns a readable default name such as "Chrome on Android"
+func (ua UserAgent) DeviceName() string {
+	browser := strings.Fields(ua.Browser)
+	switch {
+	case len(browser) > 0 && ua.OS != "":
+		return strings.Join(browser[:len(browser)-1], " ") + " on " + ua.OS
+	case ua.OS != "":
+		return ua.OS + " device"
+	case len(browser) > 0:
+		return strings.Join(browser[:len(browser)-1], " ")
+	}
+	return "Unknown device"
+}